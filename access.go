@@ -0,0 +1,188 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/welldigital/nhs-fhir/model"
+)
+
+// AccessMode the way the client accesses the API, this determines which operations are allowed.
+// https://digital.nhs.uk/developer/api-catalogue/personal-demographics-service-fhir#api-description__access-modes
+type AccessMode string
+
+const (
+	// ApplicationRestricted access without an end user, searches return at most 1 result and patients can't be updated.
+	// This is the default access mode.
+	ApplicationRestricted AccessMode = "application-restricted"
+	// HealthcareWorker user-restricted access by a healthcare worker who has logged in with NHS CIS2 using a smartcard or authenticator.
+	HealthcareWorker AccessMode = "healthcare-worker"
+	// PatientAccess user-restricted access by a patient who has logged in with NHS login at identity level P9.
+	// Patients can only read and update some parts of their own record and can't search.
+	PatientAccess AccessMode = "patient-access"
+)
+
+const sessionURIDHeader = "NHSD-Session-URID"
+
+// ErrOperationNotAllowed error for when an operation isn't allowed in the client's access mode
+var ErrOperationNotAllowed = errors.New("operation not allowed in this access mode")
+
+// ErrInvalidAccessMode error for when the access mode isn't one of the supported modes
+var ErrInvalidAccessMode = errors.New("access mode is invalid")
+
+// ErrRoleIDMissing error for when healthcare worker access is used without the user's role id
+var ErrRoleIDMissing = errors.New("role id is missing but required for healthcare worker access")
+
+// ErrUserTokenSourceMissing error for when user-restricted access is used without a TokenSource for the user's access token
+var ErrUserTokenSourceMissing = errors.New("token source for the user access token is missing but required for user-restricted access")
+
+// HealthcareWorkerOptions the options used for healthcare worker access.
+// The user's access token obtained from the CIS2 login is provided with Options.TokenSource.
+type HealthcareWorkerOptions struct {
+	// RoleID the id of the role (URID) the healthcare worker selected when logging in with CIS2.
+	// It's sent in the NHSD-Session-URID header of every request.
+	RoleID string
+}
+
+// AccessModeError is returned before a request is sent when the operation isn't allowed in the client's access mode.
+// Use errors.Is(err, ErrOperationNotAllowed) to check for this error.
+type AccessModeError struct {
+	// Mode the access mode of the client
+	Mode AccessMode
+	// Operation the operation that isn't allowed
+	Operation string
+	// Reason why the operation isn't allowed
+	Reason string
+}
+
+func (e *AccessModeError) Error() string {
+	return fmt.Sprintf("%v is not allowed with %v access: %v", e.Operation, e.Mode, e.Reason)
+}
+
+// Is reports whether target is ErrOperationNotAllowed
+func (e *AccessModeError) Is(target error) bool {
+	return target == ErrOperationNotAllowed
+}
+
+// access the access mode of the client and what's needed to check an operation is allowed before it's sent
+type access struct {
+	mode AccessMode
+	// nhsNumber the NHS number of the patient logged in with NHS login, only set for PatientAccess
+	nhsNumber string
+}
+
+// patientUpdatablePaths the elements patients can update themselves, such as their contact details and nominated pharmacy
+var patientUpdatablePaths = []string{"/telecom", "/extension"}
+
+// maxResults the maximum number of results a search can return
+func (a access) maxResults() int {
+	if a.mode == HealthcareWorker {
+		return 50
+	}
+	return 1
+}
+
+// validate checks the access mode is supported and has been configured.
+// The claims of the NHS login ID token are returned for PatientAccess, they're nil for the other access modes.
+func (m AccessMode) validate(opts *Options) (*NHSLoginClaims, error) {
+	switch m {
+	case ApplicationRestricted:
+		return nil, nil
+	case HealthcareWorker:
+		if opts.HealthcareWorkerOptions == nil || opts.HealthcareWorkerOptions.RoleID == "" {
+			return nil, ErrRoleIDMissing
+		}
+		if opts.TokenSource == nil {
+			return nil, ErrUserTokenSourceMissing
+		}
+		return nil, nil
+	case PatientAccess:
+		if opts.PatientAccessOptions == nil || opts.PatientAccessOptions.IDToken == "" {
+			return nil, ErrIDTokenMissing
+		}
+		claims, err := ParseNHSLoginIDToken(opts.PatientAccessOptions.IDToken)
+		if err != nil {
+			return nil, err
+		}
+		if opts.TokenSource == nil && opts.AuthConfigOptions == nil {
+			return nil, ErrUserTokenSourceMissing
+		}
+		return claims, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAccessMode, m)
+	}
+}
+
+// checkGet checks the patient can be read in the access mode
+func (a access) checkGet(id string) error {
+	if a.mode == PatientAccess && id != a.nhsNumber {
+		return &AccessModeError{
+			Mode:      a.mode,
+			Operation: "get",
+			Reason:    "patients can only read their own record",
+		}
+	}
+	return nil
+}
+
+// checkSearch checks the search is allowed in the access mode
+func (a access) checkSearch(opts PatientSearchOptions) error {
+	if a.mode == PatientAccess {
+		return &AccessModeError{
+			Mode:      a.mode,
+			Operation: "search",
+			Reason:    "patients can't search the PDS",
+		}
+	}
+	if max := a.maxResults(); opts.MaxResults > max {
+		return &AccessModeError{
+			Mode:      a.mode,
+			Operation: "search",
+			Reason:    fmt.Sprintf("max results must be at most %v got %v", max, opts.MaxResults),
+		}
+	}
+	return nil
+}
+
+// checkUpdate checks updating the patient with the patch is allowed in the access mode
+func (a access) checkUpdate(nhsNumber string, patch []model.PatchOp) error {
+	switch a.mode {
+	case HealthcareWorker:
+		return nil
+	case PatientAccess:
+		if nhsNumber != a.nhsNumber {
+			return &AccessModeError{
+				Mode:      a.mode,
+				Operation: "update",
+				Reason:    "patients can only update their own record",
+			}
+		}
+		for _, op := range patch {
+			if op.Op != model.PatchOpTest && !isPatientUpdatable(op.Path) {
+				return &AccessModeError{
+					Mode:      a.mode,
+					Operation: "update",
+					Reason:    fmt.Sprintf("patients can't update %v", op.Path),
+				}
+			}
+		}
+		return nil
+	default:
+		return &AccessModeError{
+			Mode:      a.mode,
+			Operation: "update",
+			Reason:    "patients can only be updated with user-restricted access",
+		}
+	}
+}
+
+// isPatientUpdatable reports whether the path is an element patients can update themselves
+func isPatientUpdatable(path string) bool {
+	for _, p := range patientUpdatablePaths {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/welldigital/nhs-fhir/model"
+)
+
+func TestNewClientWithOptions_accessMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *Options
+		want    AccessMode
+		wantErr error
+	}{
+		{
+			name: "defaults to application-restricted",
+			opts: &Options{},
+			want: ApplicationRestricted,
+		},
+		{
+			name: "healthcare worker",
+			opts: &Options{
+				AccessMode:              HealthcareWorker,
+				HealthcareWorkerOptions: &HealthcareWorkerOptions{RoleID: "555021935107"},
+				TokenSource:             StaticTokenSource("user-token"),
+			},
+			want: HealthcareWorker,
+		},
+		{
+			name: "healthcare worker without role id",
+			opts: &Options{
+				AccessMode:  HealthcareWorker,
+				TokenSource: StaticTokenSource("user-token"),
+			},
+			wantErr: ErrRoleIDMissing,
+		},
+		{
+			name: "healthcare worker without user token",
+			opts: &Options{
+				AccessMode:              HealthcareWorker,
+				HealthcareWorkerOptions: &HealthcareWorkerOptions{RoleID: "555021935107"},
+			},
+			wantErr: ErrUserTokenSourceMissing,
+		},
+		{
+			name:    "unknown access mode",
+			opts:    &Options{AccessMode: "admin"},
+			wantErr: ErrInvalidAccessMode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClientWithOptions(tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewClientWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				assert.Equal(t, tt.want, c.accessGetter().mode)
+			}
+		})
+	}
+}
+
+func TestHealthcareWorker_requests(t *testing.T) {
+	var requests int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "555021935107", r.Header.Get(sessionURIDHeader))
+		assert.Equal(t, "Bearer user-token", r.Header.Get("Authorization"))
+		if r.Method == http.MethodPatch {
+			w.Write([]byte(`{"resourceType":"Patient"}`))
+			return
+		}
+		w.Write([]byte(`{"resourceType":"Bundle"}`))
+	}))
+	defer svr.Close()
+
+	c, err := NewClientWithOptions(&Options{
+		Client:                  svr.Client(),
+		BaseURL:                 svr.URL + "/",
+		AccessMode:              HealthcareWorker,
+		HealthcareWorkerOptions: &HealthcareWorkerOptions{RoleID: "555021935107"},
+		TokenSource:             StaticTokenSource("user-token"),
+	})
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+
+	if _, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{MaxResults: 50}); err != nil {
+		t.Errorf("expected search for 50 results to be allowed got %v", err)
+	}
+	_, _, err = c.Patient.Search(context.Background(), PatientSearchOptions{MaxResults: 51})
+	if !errors.Is(err, ErrOperationNotAllowed) {
+		t.Errorf("expected %v got %v", ErrOperationNotAllowed, err)
+	}
+
+	patch := []model.PatchOp{{Op: model.PatchOpReplace, Path: "/gender", Value: "male"}}
+	if _, _, err := c.Patient.Update(context.Background(), "9000000009", "1", patch); err != nil {
+		t.Errorf("expected update to be allowed got %v", err)
+	}
+
+	assert.Equal(t, 2, requests)
+}
+
+func TestApplicationRestricted_failsEarly(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected no request to be sent")
+	}))
+	defer svr.Close()
+
+	c, _ := NewClientWithOptions(&Options{Client: svr.Client(), BaseURL: svr.URL + "/"})
+
+	_, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{MaxResults: 2})
+	var accessErr *AccessModeError
+	if !errors.As(err, &accessErr) {
+		t.Fatalf("expected AccessModeError got %v", err)
+	}
+	assert.Equal(t, ApplicationRestricted, accessErr.Mode)
+	assert.Equal(t, "search", accessErr.Operation)
+
+	patch := []model.PatchOp{{Op: model.PatchOpReplace, Path: "/gender", Value: "male"}}
+	_, _, err = c.Patient.Update(context.Background(), "9000000009", "1", patch)
+	if !errors.Is(err, ErrOperationNotAllowed) {
+		t.Errorf("expected %v got %v", ErrOperationNotAllowed, err)
+	}
+}
+
+func newNHSLoginIDToken(t *testing.T, nhsNumber, level string) string {
+	claims := jwt.MapClaims{"sub": "nhs-login-user", "identity_proofing_level": level}
+	if nhsNumber != "" {
+		claims["nhs_number"] = nhsNumber
+	}
+	// the signature isn't verified by the client
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("couldnt sign id token: %v", err)
+	}
+	return token
+}
+
+func TestNewClientWithOptions_patientAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *Options
+		wantErr error
+	}{
+		{
+			name: "patient access",
+			opts: &Options{
+				AccessMode:           PatientAccess,
+				PatientAccessOptions: &PatientAccessOptions{IDToken: newNHSLoginIDToken(t, "9000000009", "P9")},
+				TokenSource:          StaticTokenSource("user-token"),
+			},
+		},
+		{
+			name:    "id token missing",
+			opts:    &Options{AccessMode: PatientAccess, TokenSource: StaticTokenSource("user-token")},
+			wantErr: ErrIDTokenMissing,
+		},
+		{
+			name: "nhs number missing",
+			opts: &Options{
+				AccessMode:           PatientAccess,
+				PatientAccessOptions: &PatientAccessOptions{IDToken: newNHSLoginIDToken(t, "", "P9")},
+				TokenSource:          StaticTokenSource("user-token"),
+			},
+			wantErr: ErrNHSNumberClaimMissing,
+		},
+		{
+			name: "identity not proved to P9",
+			opts: &Options{
+				AccessMode:           PatientAccess,
+				PatientAccessOptions: &PatientAccessOptions{IDToken: newNHSLoginIDToken(t, "9000000009", "P5")},
+				TokenSource:          StaticTokenSource("user-token"),
+			},
+			wantErr: ErrIdentityProofingLevel,
+		},
+		{
+			name: "no access token",
+			opts: &Options{
+				AccessMode:           PatientAccess,
+				PatientAccessOptions: &PatientAccessOptions{IDToken: newNHSLoginIDToken(t, "9000000009", "P9")},
+			},
+			wantErr: ErrUserTokenSourceMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClientWithOptions(tt.opts); !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewClientWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPatientAccess_requests(t *testing.T) {
+	idToken := newNHSLoginIDToken(t, "9000000009", "P9")
+	var exchanges int
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/token" {
+			exchanges++
+			assert.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", r.FormValue("grant_type"))
+			assert.Equal(t, "urn:ietf:params:oauth:token-type:id_token", r.FormValue("subject_token_type"))
+			assert.Equal(t, idToken, r.FormValue("subject_token"))
+			assert.NotEmpty(t, r.FormValue("client_assertion"))
+			w.Write([]byte(`{"access_token":"patient-token","expires_in":"599","token_type":"Bearer"}`))
+			return
+		}
+		assert.Equal(t, "Bearer patient-token", r.Header.Get("Authorization"))
+		assert.Equal(t, "/"+path+"/9000000009", r.URL.Path)
+		w.Write([]byte(`{"resourceType":"Patient","id":"9000000009"}`))
+	}))
+	defer svr.Close()
+
+	c := newTestClient(t, svr, &Options{
+		AccessMode:           PatientAccess,
+		PatientAccessOptions: &PatientAccessOptions{IDToken: idToken},
+	})
+	ctx := context.Background()
+
+	if _, _, err := c.Patient.Get(ctx, "9000000009"); err != nil {
+		t.Errorf("expected the patient to be able to read their own record got %v", err)
+	}
+	if _, _, err := c.Patient.Get(ctx, "9449304424"); !errors.Is(err, ErrOperationNotAllowed) {
+		t.Errorf("expected %v got %v", ErrOperationNotAllowed, err)
+	}
+	if _, _, err := c.Patient.Search(ctx, PatientSearchOptions{MaxResults: 1}); !errors.Is(err, ErrOperationNotAllowed) {
+		t.Errorf("expected %v got %v", ErrOperationNotAllowed, err)
+	}
+
+	telecom := []model.PatchOp{
+		{Op: model.PatchOpTest, Path: "/telecom/0/id", Value: "1"},
+		{Op: model.PatchOpReplace, Path: "/telecom/0/value", Value: "01632960587"},
+	}
+	if _, _, err := c.Patient.Update(ctx, "9000000009", "1", telecom); err != nil {
+		t.Errorf("expected the patient to be able to update their telecom got %v", err)
+	}
+	if _, _, err := c.Patient.Update(ctx, "9449304424", "1", telecom); !errors.Is(err, ErrOperationNotAllowed) {
+		t.Errorf("expected %v got %v", ErrOperationNotAllowed, err)
+	}
+	name := []model.PatchOp{{Op: model.PatchOpReplace, Path: "/name/0/family", Value: "Smith"}}
+	if _, _, err := c.Patient.Update(ctx, "9000000009", "1", name); !errors.Is(err, ErrOperationNotAllowed) {
+		t.Errorf("expected %v got %v", ErrOperationNotAllowed, err)
+	}
+
+	assert.Equal(t, 1, exchanges)
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -11,6 +12,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/welldigital/nhs-fhir/jwks"
 )
 
 // Claims wrapper around jwt claims
@@ -74,6 +76,48 @@ type AuthConfigOptions struct {
 
 	// SigningMethod to be used when signing/verifing tokens, must be RSA
 	SigningMethod jwt.SigningMethod
+
+	// AssertionLifetime how long the signed JWT sent to the token endpoint is valid for.
+	// A new JWT is signed for every token request. Defaults to 5 minutes, which is the maximum allowed by NHS auth
+	AssertionLifetime time.Duration
+
+	// KeyRing holds several keys so they can be rotated without downtime, the kid and key used to sign are the active key of the ring.
+	// When set Kid, PrivateKey and PrivateKeyPemFile aren't needed. Serve the public keys of the ring with jwks.Handler
+	KeyRing *jwks.KeyRing
+
+	// KeyProvider provides the key used to sign and its kid, such as a key held in an HSM or cloud KMS or an encrypted key file.
+	// When set Kid, PrivateKey and PrivateKeyPemFile aren't needed. See NewSignerKeyProvider, NewPEMKeyProvider,
+	// NewPKCS12KeyProvider and NewFileKeyProvider
+	KeyProvider KeyProvider
+
+	// TokenCache shares access tokens between clients, processes and replicas so each of them doesn't request its own.
+	// See NewMemoryTokenCache, NewFileTokenCache and NewKeyValueTokenCache
+	TokenCache TokenCache
+
+	// RefreshWindow the access token is refreshed when it expires within this window,
+	// this stops a token expiring while a request is in flight. Defaults to 30 seconds
+	RefreshWindow time.Duration
+}
+
+const (
+	defaultRefreshWindow     = 30 * time.Second
+	defaultAssertionLifetime = 5 * time.Minute
+)
+
+// assertionLifetime returns the configured lifetime of the signed JWT or the default
+func (c *AuthConfigOptions) assertionLifetime() time.Duration {
+	if c == nil || c.AssertionLifetime <= 0 {
+		return defaultAssertionLifetime
+	}
+	return c.AssertionLifetime
+}
+
+// refreshWindow returns the configured refresh window or the default
+func (c *AuthConfigOptions) refreshWindow() time.Duration {
+	if c == nil || c.RefreshWindow <= 0 {
+		return defaultRefreshWindow
+	}
+	return c.RefreshWindow
 }
 
 func isNil(i interface{}) bool {
@@ -90,34 +134,54 @@ var ErrKeyMissing = errors.New("private key or private key file must be specifie
 // ErrInvalidSigningMethodAlg error for when using a signing algorithm that isnt RSA
 var ErrInvalidSigningMethodAlg = errors.New("signing method must be RSA")
 
-// Validate validates the auth config options and returns an error if it's not valid
+// Validate validates the auth config options and returns an error if it's not valid.
+// Every problem found is returned together, use errors.Is to check for a particular one e.g. errors.Is(err, ErrKidMissing)
 func (c AuthConfigOptions) Validate() error {
+	var errs []error
+
 	if c.BaseURL == "" {
-		return ErrBaseURLMissing
-	}
-	if err := IsAbsoluteURL(c.BaseURL); err != nil {
-		return err
+		errs = append(errs, ErrBaseURLMissing)
+	} else if err := IsAbsoluteURL(c.BaseURL); err != nil {
+		errs = append(errs, err)
 	}
 
-	if c.Kid == "" {
-		return ErrKidMissing
+	if c.Kid == "" && c.KeyRing == nil && c.KeyProvider == nil {
+		errs = append(errs, ErrKidMissing)
 	}
 	if c.ClientID == "" {
-		return ErrClientIDMissing
+		errs = append(errs, ErrClientIDMissing)
 	}
 
-	if len(c.PrivateKey) == 0 && c.PrivateKeyPemFile == "" && c.Signer == nil {
-		return ErrKeyMissing
+	if len(c.PrivateKey) == 0 && c.PrivateKeyPemFile == "" && c.Signer == nil && c.KeyRing == nil && c.KeyProvider == nil {
+		errs = append(errs, ErrKeyMissing)
 	}
 
 	if !isNil(c.SigningMethod) && !strings.Contains(c.SigningMethod.Alg(), "RS") {
-		return ErrInvalidSigningMethodAlg
+		errs = append(errs, ErrInvalidSigningMethodAlg)
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-func generateSecret(config AuthConfigOptions) (*string, error) {
+// NewClientAssertion signs a new JWT used to authenticate your application at the token endpoint given by audience,
+// for example when exchanging a code from NHS CIS2. This is the same client assertion used to request access tokens from NHS auth.
+// The JWT has a unique id (jti) and can only be used once so a new one must be generated for every token request.
+func NewClientAssertion(config AuthConfigOptions, audience string, now time.Time) (string, error) {
+	jwt, err := signClientAssertion(config, audience, now)
+	if err != nil {
+		return "", err
+	}
+	return *jwt, nil
+}
+
+// generateSecret signs a new JWT used as the client assertion when requesting an access token.
+// The JWT has a unique id (jti) and can only be used once so a new one must be generated for every token request.
+func generateSecret(config AuthConfigOptions, now time.Time) (*string, error) {
+	return signClientAssertion(config, config.BaseURL+"/oauth2/token", now)
+}
+
+// signClientAssertion signs a new JWT for the token endpoint given by audience
+func signClientAssertion(config AuthConfigOptions, audience string, now time.Time) (*string, error) {
 
 	err := config.Validate()
 
@@ -126,55 +190,114 @@ func generateSecret(config AuthConfigOptions) (*string, error) {
 	}
 
 	claims := jwt.StandardClaims{
-		Audience:  config.BaseURL + "/oauth2/token",
+		Audience:  audience,
 		Id:        uuid.NewString(),
-		ExpiresAt: time.Now().Add(time.Minute * 5).Unix(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(config.assertionLifetime()).Unix(),
 		Issuer:    config.ClientID,
 		Subject:   config.ClientID,
 	}
 
-	var jwtToken *jwt.Token
+	if config.Signer != nil {
+		// use custom signer to get signed jwt token
+		jwtToken := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
+		if config.SigningMethod != nil {
+			jwtToken = jwt.NewWithClaims(config.SigningMethod, claims)
+		}
+		jwtToken.Header["kid"] = config.Kid
 
-	if config.SigningMethod != nil {
-		jwtToken = jwt.NewWithClaims(config.SigningMethod, claims)
-	} else {
-		jwtToken = jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
+		tokenSigned, err := config.Signer(jwtToken, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error signing jwt with key using custom signer: %v", err)
+		}
+		return &tokenSigned, nil
 	}
-	jwtToken.Header["kid"] = config.Kid
 
-	secretKey := config.PrivateKey
+	signer, kid, alg, err := signingKey(config, now)
+	if err != nil {
+		return nil, err
+	}
 
-	if config.PrivateKeyPemFile != "" {
-		secretKey, err = ioutil.ReadFile(config.PrivateKeyPemFile)
+	keyMethod, err := keySigningMethod(config.SigningMethod, alg)
+	if err != nil {
+		return nil, err
+	}
+	method, err := newSignerMethod(keyMethod)
+	if err != nil {
+		return nil, err
+	}
+	jwtToken := jwt.NewWithClaims(method, claims)
+	jwtToken.Header["kid"] = kid
 
-		if err != nil {
-			return nil, err
-		}
+	tokenSigned, err := jwtToken.SignedString(signer)
+	if err != nil {
+		return nil, fmt.Errorf("error signing jwt with key %v: %w", kid, err)
 	}
+	return &tokenSigned, nil
+}
 
-	var tokenSigned string
+// keySigningMethod returns the signing method to use with a key published with the alg.
+// The alg is used when no method is configured, a configured method must match it.
+func keySigningMethod(method jwt.SigningMethod, alg string) (jwt.SigningMethod, error) {
+	if alg == "" {
+		return method, nil
+	}
+	if isNil(method) {
+		return jwt.GetSigningMethod(alg), nil
+	}
+	if method.Alg() != alg {
+		return nil, fmt.Errorf("%w: the signing method is %v but the key is published with %v", ErrInvalidSigningMethodAlg, method.Alg(), alg)
+	}
+	return method, nil
+}
 
-	if config.Signer == nil {
-		key, err := jwt.ParseRSAPrivateKeyFromPEM(secretKey)
+// signingKid returns the kid the client assertion is signed with, or the configured kid if the key can't be found
+func signingKid(config AuthConfigOptions, now time.Time) string {
+	if config.KeyProvider != nil || config.KeyRing != nil {
+		if _, kid, _, err := signingKey(config, now); err == nil {
+			return kid
+		}
+	}
+	return config.Kid
+}
+
+// signingKey returns the key to sign the client assertion with, its kid and the alg it's published with if it's known.
+// The key comes from the key provider, the active key of the key ring or the PEM encoded private key, in that order.
+func signingKey(config AuthConfigOptions, now time.Time) (crypto.Signer, string, string, error) {
+	if config.KeyProvider != nil {
+		signer, kid, err := config.KeyProvider.Key()
 		if err != nil {
-			return nil, fmt.Errorf("error parsing RSA private key: %v", err)
+			return nil, "", "", fmt.Errorf("error getting key from key provider: %w", err)
 		}
+		if err := checkRSASigner(signer); err != nil {
+			return nil, "", "", err
+		}
+		if kid == "" {
+			kid = config.Kid
+		}
+		return signer, kid, "", nil
+	}
 
-		tokenSigned, err = jwtToken.SignedString(key)
+	if config.KeyRing != nil {
+		active, err := config.KeyRing.Active(now)
 		if err != nil {
-			return nil, fmt.Errorf("error signing jwt with key: %v", err)
+			return nil, "", "", err
 		}
+		return active.PrivateKey, active.Kid, active.Alg, nil
+	}
 
-	} else {
-		// use custom signer to get signed jwt token
-		tokenSigned, err = config.Signer(jwtToken, nil)
+	secretKey := config.PrivateKey
+	if config.PrivateKeyPemFile != "" {
+		var err error
+		secretKey, err = ioutil.ReadFile(config.PrivateKeyPemFile)
 		if err != nil {
-			return nil, fmt.Errorf("error signing jwt with key using custom signer: %v", err)
+			return nil, "", "", err
 		}
 	}
 
+	key, err := ParsePrivateKeyPEM(secretKey, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", "", fmt.Errorf("error parsing RSA private key: %w", err)
 	}
-	return &tokenSigned, nil
+	return key, config.Kid, "", nil
 }
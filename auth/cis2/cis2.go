@@ -0,0 +1,365 @@
+/*
+Package cis2 logs healthcare workers in with NHS Care Identity Service 2 (CIS2) using the OpenID Connect
+authorization code flow with PKCE.
+
+The access token obtained is used with the HealthcareWorker access mode of the client and the role id selected
+by the user is sent in the NHSD-Session-URID header.
+
+https://digital.nhs.uk/services/care-identity-service/applications-and-services/cis2-authentication/guidance-for-developers
+
+Usage:
+
+	provider, err := cis2.NewProvider(ctx, cis2.Config{
+		Issuer:      "https://am.nhsint.auth-ptl.cis2.spineservices.nhs.uk:443/openam/oauth2/realms/root/realms/NHSIdentity/realms/Healthcare",
+		RedirectURL: "https://your-app/callback",
+		AuthConfigOptions: client.AuthConfigOptions{
+			ClientID:          "your-cis2-client-id",
+			Kid:               "test-1",
+			PrivateKeyPemFile: "path/to/private/key/key.pem",
+		},
+	})
+
+	// redirect the user to authReq.URL and keep the request in their session
+	authReq, err := provider.NewAuthRequest()
+
+	// in the callback
+	tokens, err := provider.Exchange(ctx, authReq, r.FormValue("state"), r.FormValue("code"))
+
+	c, err := client.NewClientWithOptions(&client.Options{
+		AccessMode:              client.HealthcareWorker,
+		HealthcareWorkerOptions: &client.HealthcareWorkerOptions{RoleID: tokens.IDTokenClaims.SelectedRoleID},
+		TokenSource:             provider.TokenSource(tokens),
+	})
+*/
+package cis2
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	client "github.com/welldigital/nhs-fhir"
+)
+
+// ErrIssuerMissing error for when the issuer of the CIS2 environment is missing
+var ErrIssuerMissing = errors.New("issuer is missing but required")
+
+// ErrRedirectURLMissing error for when the redirect url is missing
+var ErrRedirectURLMissing = errors.New("redirect url is missing but required")
+
+// ErrStateMismatch error for when the state returned to the redirect url doesn't match the state of the auth request.
+// This can mean the request was forged so the code must not be exchanged.
+var ErrStateMismatch = errors.New("state doesn't match the auth request")
+
+// ErrCodeMissing error for when the code returned to the redirect url is empty
+var ErrCodeMissing = errors.New("authorization code is missing")
+
+// ErrRefreshTokenMissing error for when tokens can't be refreshed because there isn't a refresh token
+var ErrRefreshTokenMissing = errors.New("refresh token is missing")
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// DefaultScopes the scopes requested when Config.Scopes is empty.
+// nationalrbacaccess returns the roles of the user.
+var DefaultScopes = []string{"openid", "profile", "nationalrbacaccess"}
+
+// Config the options used to log in with CIS2
+type Config struct {
+	// Issuer the issuer of the CIS2 environment, the endpoints are discovered from its openid-configuration
+	Issuer string
+	// RedirectURL the url CIS2 sends the user back to with the code after they've logged in
+	RedirectURL string
+	// Scopes the scopes to request. Defaults to DefaultScopes
+	Scopes []string
+	// AuthConfigOptions the client id and key used to sign the client assertion sent to the token endpoint.
+	// BaseURL isn't used and defaults to the issuer.
+	client.AuthConfigOptions
+	// HTTPClient the client used to call CIS2. Defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Now returns the current time, used when validating the ID token. Defaults to time.Now
+	Now func() time.Time
+}
+
+// Endpoints the endpoints of the CIS2 environment
+type Endpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider logs users in with CIS2
+type Provider struct {
+	config    Config
+	endpoints Endpoints
+	keys      *keySet
+}
+
+// NewProvider returns a new Provider, the endpoints are discovered from the issuer
+func NewProvider(ctx context.Context, config Config) (*Provider, error) {
+	if config.Issuer == "" {
+		return nil, ErrIssuerMissing
+	}
+	if config.RedirectURL == "" {
+		return nil, ErrRedirectURLMissing
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = config.Issuer
+	}
+	if err := config.AuthConfigOptions.Validate(); err != nil {
+		return nil, err
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	endpoints, err := discover(ctx, config.HTTPClient, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		config:    config,
+		endpoints: *endpoints,
+		keys:      &keySet{uri: endpoints.JWKSURI, httpClient: config.HTTPClient, now: config.Now},
+	}, nil
+}
+
+// Endpoints returns the endpoints discovered from the issuer
+func (p *Provider) Endpoints() Endpoints {
+	return p.endpoints
+}
+
+// discover gets the endpoints from the openid-configuration of the issuer
+func discover(ctx context.Context, httpClient *http.Client, issuer string) (*Endpoints, error) {
+	u := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	endpoints := &Endpoints{}
+	if err := getJSON(ctx, httpClient, u, "", endpoints); err != nil {
+		return nil, fmt.Errorf("error discovering cis2 endpoints: %w", err)
+	}
+	if endpoints.Issuer != issuer {
+		return nil, fmt.Errorf("error discovering cis2 endpoints: issuer %q doesn't match %q", endpoints.Issuer, issuer)
+	}
+	return endpoints, nil
+}
+
+// AuthRequest a request sent to CIS2 to log a user in.
+// Keep it in the user's session as it's required to exchange the code when the user is redirected back.
+type AuthRequest struct {
+	// URL the url to redirect the user to
+	URL string
+	// State protects against cross site request forgery, it's returned with the code
+	State string
+	// Nonce is returned in the ID token to protect against replay attacks
+	Nonce string
+	// CodeVerifier the PKCE secret sent when exchanging the code
+	CodeVerifier string
+}
+
+// NewAuthRequest creates a request to log a user in with a new state, nonce and PKCE code verifier
+func (p *Provider) NewAuthRequest() (*AuthRequest, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(p.endpoints.AuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(p.config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return &AuthRequest{
+		URL:          u.String(),
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}, nil
+}
+
+// Tokens the tokens issued by CIS2
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	Expiry       time.Time
+	// IDTokenClaims the claims of the validated ID token, nil when the token response didn't contain one
+	IDTokenClaims *IDTokenClaims
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	IDToken          string `json:"id_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange checks the state and exchanges the code the user was redirected back with for tokens.
+// The ID token is validated against the keys of the issuer and must contain the nonce of the auth request.
+func (p *Provider) Exchange(ctx context.Context, authReq *AuthRequest, state, code string) (*Tokens, error) {
+	if authReq == nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(authReq.State)) != 1 {
+		return nil, ErrStateMismatch
+	}
+	if code == "" {
+		return nil, ErrCodeMissing
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", p.config.RedirectURL)
+	data.Set("code_verifier", authReq.CodeVerifier)
+
+	return p.requestTokens(ctx, data, authReq.Nonce)
+}
+
+// Refresh uses the refresh token to get new tokens
+func (p *Provider) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	if refreshToken == "" {
+		return nil, ErrRefreshTokenMissing
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+
+	return p.requestTokens(ctx, data, "")
+}
+
+// requestTokens posts the data to the token endpoint, authenticating with a signed client assertion
+func (p *Provider) requestTokens(ctx context.Context, data url.Values, nonce string) (*Tokens, error) {
+	assertion, err := client.NewClientAssertion(p.config.AuthConfigOptions, p.endpoints.TokenEndpoint, p.config.Now())
+	if err != nil {
+		return nil, err
+	}
+	data.Set("client_id", p.config.ClientID)
+	data.Set("client_assertion_type", clientAssertionType)
+	data.Set("client_assertion", assertion)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoints.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	tr := &tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tr); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error decoding cis2 token response, status code: %v: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return nil, fmt.Errorf("cis2 token request failed, status code: %v, error: %v %v", resp.StatusCode, tr.Error, tr.ErrorDescription)
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("cis2 token response doesn't contain an access token")
+	}
+
+	tokens := &Tokens{
+		AccessToken:  tr.AccessToken,
+		RefreshToken: tr.RefreshToken,
+		IDToken:      tr.IDToken,
+	}
+	if tr.ExpiresIn > 0 {
+		tokens.Expiry = p.config.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+
+	// the ID token is required when logging in but may not be sent again on refresh
+	if tr.IDToken != "" || nonce != "" {
+		claims, err := p.VerifyIDToken(ctx, tr.IDToken, nonce)
+		if err != nil {
+			return nil, err
+		}
+		tokens.IDTokenClaims = claims
+	}
+
+	return tokens, nil
+}
+
+// UserInfo gets the claims of the user from the userinfo endpoint, this includes the roles of the user
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	if err := getJSON(ctx, p.config.HTTPClient, p.endpoints.UserInfoEndpoint, accessToken, claims); err != nil {
+		return nil, fmt.Errorf("error getting cis2 user info: %w", err)
+	}
+	return claims, nil
+}
+
+// getJSON gets the url and decodes the json response into v
+func getJSON(ctx context.Context, httpClient *http.Client, u string, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// randomString returns 32 random bytes encoded as url safe base64, this is also a valid PKCE code verifier
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge returns the S256 PKCE code challenge of the verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
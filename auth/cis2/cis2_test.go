@@ -0,0 +1,443 @@
+package cis2
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	client "github.com/welldigital/nhs-fhir"
+)
+
+const (
+	testClientID = "cis2-client"
+	testKid      = "client-key"
+	providerKid  = "provider-key"
+)
+
+// fakeProvider an in-process OIDC provider which issues codes and tokens like CIS2
+type fakeProvider struct {
+	t         *testing.T
+	svr       *httptest.Server
+	key       *rsa.PrivateKey
+	clientKey *rsa.PrivateKey
+	now       time.Time
+
+	mu     sync.Mutex
+	codes  map[string]url.Values
+	issued int
+	// jwksRequests the number of times the keys were fetched
+	jwksRequests int
+	// idClaims modifies the claims of the ID tokens issued
+	idClaims func(claims jwt.MapClaims)
+	// signingKey signs the ID tokens, defaults to key
+	signingKey *rsa.PrivateKey
+}
+
+func newFakeProvider(t *testing.T, clientKey *rsa.PrivateKey) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldnt generate key: %v", err)
+	}
+	f := &fakeProvider{t: t, key: key, clientKey: clientKey, codes: map[string]url.Values{}, now: time.Now()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Endpoints{
+			Issuer:                f.svr.URL,
+			AuthorizationEndpoint: f.svr.URL + "/authorize",
+			TokenEndpoint:         f.svr.URL + "/access_token",
+			UserInfoEndpoint:      f.svr.URL + "/userinfo",
+			JWKSURI:               f.svr.URL + "/jwk_uri",
+		})
+	})
+	mux.HandleFunc("/jwk_uri", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.jwksRequests++
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": providerKid,
+				"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/access_token", f.token)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"sub":"user","nhsid_useruid":"910000000001","nhsid_nrbac_roles":[{"person_roleid":"555021935107","role_code":"S0030:G0100:R0570","org_code":"X26"}]}`))
+	})
+	f.svr = httptest.NewServer(mux)
+	return f
+}
+
+// authorize logs the user in and returns the code and state sent to the redirect url
+func (f *fakeProvider) authorize(authURL string) (code, state string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		f.t.Fatalf("invalid auth url: %v", err)
+	}
+	q := u.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code = "code-" + q.Get("state")
+	f.codes[code] = q
+	return code, q.Get("state")
+}
+
+func (f *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	// the client must authenticate with a signed JWT for the token endpoint,
+	// the times are signed with the fake clock so aren't validated
+	assertion := &jwt.StandardClaims{}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(r.FormValue("client_assertion"), assertion, func(token *jwt.Token) (interface{}, error) {
+		return &f.clientKey.PublicKey, nil
+	})
+	if err != nil || assertion.Audience != f.svr.URL+"/access_token" || assertion.Issuer != testClientID {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid_client"}`))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	nonce := ""
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		authReq, ok := f.codes[r.FormValue("code")]
+		delete(f.codes, r.FormValue("code"))
+		if !ok || authReq.Get("code_challenge") != codeChallenge(r.FormValue("code_verifier")) ||
+			authReq.Get("redirect_uri") != r.FormValue("redirect_uri") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		nonce = authReq.Get("nonce")
+	case "refresh_token":
+		if r.FormValue("refresh_token") != "refresh-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+	}
+
+	f.issued++
+	resp := map[string]interface{}{
+		"access_token":  "access-" + string(rune('0'+f.issued)),
+		"refresh_token": "refresh-1",
+		"token_type":    "Bearer",
+		"expires_in":    300,
+	}
+	if nonce != "" {
+		resp["id_token"] = f.idToken(nonce)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeProvider) idToken(nonce string) string {
+	claims := jwt.MapClaims{
+		"iss":             f.svr.URL,
+		"sub":             "user",
+		"aud":             []string{testClientID},
+		"iat":             f.now.Unix(),
+		"exp":             f.now.Add(5 * time.Minute).Unix(),
+		"nonce":           nonce,
+		"nhsid_useruid":   "910000000001",
+		"selected_roleid": "555021935107",
+		"nhsid_nrbac_roles": []map[string]string{
+			{"person_roleid": "555021935107", "role_code": "S0030:G0100:R0570", "org_code": "X26"},
+			{"person_roleid": "555021935108", "role_code": "S8000:G8000:R8001", "org_code": "Y12345"},
+		},
+	}
+	if f.idClaims != nil {
+		f.idClaims(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = providerKid
+	key := f.key
+	if f.signingKey != nil {
+		key = f.signingKey
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		f.t.Fatalf("couldnt sign id token: %v", err)
+	}
+	return signed
+}
+
+func newTestProvider(t *testing.T) (*fakeProvider, *Provider) {
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldnt generate key: %v", err)
+	}
+	f := newFakeProvider(t, clientKey)
+	t.Cleanup(f.svr.Close)
+
+	p, err := NewProvider(context.Background(), Config{
+		Issuer:      f.svr.URL,
+		RedirectURL: "https://app.test/callback",
+		AuthConfigOptions: client.AuthConfigOptions{
+			ClientID:   testClientID,
+			Kid:        testKid,
+			PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(clientKey)}),
+		},
+		HTTPClient: f.svr.Client(),
+		Now:        func() time.Time { return f.now },
+	})
+	if err != nil {
+		t.Fatalf("couldnt create provider: %v", err)
+	}
+	return f, p
+}
+
+func TestProvider_NewAuthRequest(t *testing.T) {
+	f, p := newTestProvider(t)
+
+	authReq, err := p.NewAuthRequest()
+	if err != nil {
+		t.Fatalf("NewAuthRequest() error = %v", err)
+	}
+
+	u, _ := url.Parse(authReq.URL)
+	q := u.Query()
+	assert.Equal(t, f.svr.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, testClientID, q.Get("client_id"))
+	assert.Equal(t, "https://app.test/callback", q.Get("redirect_uri"))
+	assert.Equal(t, "openid profile nationalrbacaccess", q.Get("scope"))
+	assert.Equal(t, authReq.State, q.Get("state"))
+	assert.Equal(t, authReq.Nonce, q.Get("nonce"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, codeChallenge(authReq.CodeVerifier), q.Get("code_challenge"))
+
+	other, _ := p.NewAuthRequest()
+	assert.NotEqual(t, authReq.State, other.State)
+	assert.NotEqual(t, authReq.CodeVerifier, other.CodeVerifier)
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	f, p := newTestProvider(t)
+
+	authReq, _ := p.NewAuthRequest()
+	code, state := f.authorize(authReq.URL)
+
+	tokens, err := p.Exchange(context.Background(), authReq, state, code)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	assert.Equal(t, "access-1", tokens.AccessToken)
+	assert.Equal(t, "refresh-1", tokens.RefreshToken)
+	assert.Equal(t, f.now.Add(5*time.Minute), tokens.Expiry)
+	assert.Equal(t, "555021935107", tokens.IDTokenClaims.SelectedRoleID)
+	assert.Equal(t, []string{"555021935107", "555021935108"}, tokens.IDTokenClaims.RoleIDs())
+	assert.Equal(t, "910000000001", tokens.IDTokenClaims.UserID)
+
+	// the code can only be used once
+	if _, err := p.Exchange(context.Background(), authReq, state, code); err == nil {
+		t.Errorf("expected exchanging the code again to fail")
+	}
+}
+
+func TestProvider_Exchange_errors(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name    string
+		setup   func(f *fakeProvider, authReq *AuthRequest) (code, state string)
+		wantErr error
+	}{
+		{
+			name: "state doesn't match",
+			setup: func(f *fakeProvider, authReq *AuthRequest) (string, string) {
+				code, _ := f.authorize(authReq.URL)
+				return code, "forged"
+			},
+			wantErr: ErrStateMismatch,
+		},
+		{
+			name: "code missing",
+			setup: func(f *fakeProvider, authReq *AuthRequest) (string, string) {
+				return "", authReq.State
+			},
+			wantErr: ErrCodeMissing,
+		},
+		{
+			name: "wrong code verifier",
+			setup: func(f *fakeProvider, authReq *AuthRequest) (string, string) {
+				code, state := f.authorize(authReq.URL)
+				authReq.CodeVerifier = "wrong"
+				return code, state
+			},
+		},
+		{
+			name: "id token signed by unknown key",
+			setup: func(f *fakeProvider, authReq *AuthRequest) (string, string) {
+				f.signingKey = otherKey
+				return f.authorize(authReq.URL)
+			},
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name: "id token for another client",
+			setup: func(f *fakeProvider, authReq *AuthRequest) (string, string) {
+				f.idClaims = func(claims jwt.MapClaims) { claims["aud"] = "other-client" }
+				return f.authorize(authReq.URL)
+			},
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name: "id token from another issuer",
+			setup: func(f *fakeProvider, authReq *AuthRequest) (string, string) {
+				f.idClaims = func(claims jwt.MapClaims) { claims["iss"] = "https://evil.test" }
+				return f.authorize(authReq.URL)
+			},
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name: "id token has expired",
+			setup: func(f *fakeProvider, authReq *AuthRequest) (string, string) {
+				f.idClaims = func(claims jwt.MapClaims) { claims["exp"] = f.now.Add(-time.Second).Unix() }
+				return f.authorize(authReq.URL)
+			},
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name: "nonce doesn't match",
+			setup: func(f *fakeProvider, authReq *AuthRequest) (string, string) {
+				f.idClaims = func(claims jwt.MapClaims) { claims["nonce"] = "replayed" }
+				return f.authorize(authReq.URL)
+			},
+			wantErr: ErrInvalidIDToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, p := newTestProvider(t)
+			authReq, _ := p.NewAuthRequest()
+			code, state := tt.setup(f, authReq)
+
+			_, err := p.Exchange(context.Background(), authReq, state, code)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvider_VerifyIDToken_unknownKid(t *testing.T) {
+	f, p := newTestProvider(t)
+
+	// a token signed with a made up kid
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": f.svr.URL, "aud": testClientID})
+	token.Header["kid"] = "forged"
+	forged, err := token.SignedString(f.key)
+	if err != nil {
+		t.Fatalf("couldnt sign id token: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := p.VerifyIDToken(context.Background(), forged, "")
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	}
+	assert.Equal(t, 1, f.jwksRequests, "the keys shouldn't be fetched again for every unknown kid")
+
+	// a genuine token is still verified with the fetched keys
+	_, err = p.VerifyIDToken(context.Background(), f.idToken(""), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, f.jwksRequests)
+
+	f.now = f.now.Add(jwksRefetchInterval)
+	_, err = p.VerifyIDToken(context.Background(), forged, "")
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+	assert.Equal(t, 2, f.jwksRequests, "the keys should be fetched again once the interval has passed")
+}
+
+func TestProvider_TokenSource(t *testing.T) {
+	f, p := newTestProvider(t)
+
+	authReq, _ := p.NewAuthRequest()
+	code, state := f.authorize(authReq.URL)
+	tokens, err := p.Exchange(context.Background(), authReq, state, code)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	ts := p.TokenSource(tokens)
+	token, err := ts.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	assert.Equal(t, "access-1", token.AccessToken)
+
+	// refreshed when it's about to expire
+	f.now = f.now.Add(5 * time.Minute)
+	token, err = ts.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	assert.Equal(t, "access-2", token.AccessToken)
+	assert.Equal(t, "555021935107", ts.(*tokenSource).tokens.IDTokenClaims.SelectedRoleID)
+}
+
+func TestProvider_UserInfo(t *testing.T) {
+	_, p := newTestProvider(t)
+
+	claims, err := p.UserInfo(context.Background(), "access-1")
+	if err != nil {
+		t.Fatalf("UserInfo() error = %v", err)
+	}
+	assert.Equal(t, []string{"555021935107"}, claims.RoleIDs())
+
+	if _, err := p.UserInfo(context.Background(), "wrong"); err == nil {
+		t.Errorf("expected an error for an invalid access token")
+	}
+}
+
+func TestNewProvider_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr error
+	}{
+		{name: "issuer missing", config: Config{RedirectURL: "https://app.test"}, wantErr: ErrIssuerMissing},
+		{name: "redirect url missing", config: Config{Issuer: "https://cis2.test"}, wantErr: ErrRedirectURLMissing},
+		{
+			name:    "auth config invalid",
+			config:  Config{Issuer: "https://cis2.test", RedirectURL: "https://app.test"},
+			wantErr: client.ErrKidMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewProvider(context.Background(), tt.config); !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
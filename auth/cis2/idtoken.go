@@ -0,0 +1,202 @@
+package cis2
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// ErrInvalidIDToken error for when the ID token fails validation
+var ErrInvalidIDToken = errors.New("id token is invalid")
+
+// Role a national RBAC role of the user
+type Role struct {
+	// RoleID the id of the role (URID), this is sent in the NHSD-Session-URID header
+	RoleID string `json:"person_roleid"`
+	// RoleCode the code of the role e.g. S0030:G0100:R0570
+	RoleCode string `json:"role_code"`
+	// RoleName the name of the role
+	RoleName string `json:"role_name"`
+	// OrgCode the ODS code of the organisation the role is for
+	OrgCode string `json:"org_code"`
+}
+
+// Audience the audience of a token, it can be sent as a string or an array of strings
+type Audience []string
+
+// UnmarshalJSON decodes a string or an array of strings
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var arr []string
+	if err := json.Unmarshal(b, &arr); err != nil {
+		return err
+	}
+	*a = arr
+	return nil
+}
+
+// contains reports whether the audience contains the client id
+func (a Audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// IDTokenClaims the claims of the user sent in the ID token or returned by the userinfo endpoint
+type IDTokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	Nonce     string   `json:"nonce"`
+	Name      string   `json:"name"`
+	// UserID the unique id of the user in the spine
+	UserID string `json:"nhsid_useruid"`
+	// SelectedRoleID the id of the role the user selected when logging in
+	SelectedRoleID string `json:"selected_roleid"`
+	// Roles the national RBAC roles of the user
+	Roles []Role `json:"nhsid_nrbac_roles"`
+}
+
+// Valid is required by jwt.Claims, the claims are validated by VerifyIDToken instead
+func (c *IDTokenClaims) Valid() error {
+	return nil
+}
+
+// RoleIDs returns the ids of the user's roles
+func (c *IDTokenClaims) RoleIDs() []string {
+	ids := make([]string, 0, len(c.Roles))
+	for _, r := range c.Roles {
+		ids = append(ids, r.RoleID)
+	}
+	return ids
+}
+
+// VerifyIDToken checks the signature of the ID token against the keys of the issuer and validates its claims.
+// The nonce is only checked when it isn't empty.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	now := p.config.Now().Unix()
+	switch {
+	case claims.Issuer != p.endpoints.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q doesn't match %q", ErrInvalidIDToken, claims.Issuer, p.endpoints.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: audience %v doesn't contain %q", ErrInvalidIDToken, claims.Audience, p.config.ClientID)
+	case claims.ExpiresAt <= now:
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	case nonce != "" && claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce doesn't match the auth request", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+// jwksRefetchInterval the minimum time between fetches of the issuer's keys,
+// this stops tokens with made up kids from making every request fetch the keys
+const jwksRefetchInterval = time.Minute
+
+// keySet the public keys of the issuer, the keys are fetched again when a token is signed with an unknown kid
+// as long as they haven't been fetched within the jwksRefetchInterval
+type keySet struct {
+	uri        string
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// key returns the public key for the kid
+func (s *keySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+
+	// the issuer may have rotated its keys
+	now := s.now()
+	if s.keys != nil && now.Sub(s.fetched) < jwksRefetchInterval {
+		return nil, fmt.Errorf("no key found for kid %q", kid)
+	}
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.keys = keys
+	s.fetched = now
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key found for kid %q", kid)
+}
+
+// fetch gets the keys from the jwks uri
+func (s *keySet) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, s.httpClient, s.uri, "", &jwks); err != nil {
+		return nil, fmt.Errorf("error getting cis2 jwks: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding modulus of key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding exponent of key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,58 @@
+package cis2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	client "github.com/welldigital/nhs-fhir"
+)
+
+// refreshWindow the access token is refreshed when it expires within this window
+const refreshWindow = 30 * time.Second
+
+// tokenSource gives the user's access token and refreshes it when it expires
+type tokenSource struct {
+	provider *Provider
+
+	mu     sync.Mutex
+	tokens *Tokens
+}
+
+// TokenSource returns a client.TokenSource for the user's access token, use it with the HealthcareWorker access mode.
+// The tokens are refreshed with the refresh token when the access token expires.
+func (p *Provider) TokenSource(tokens *Tokens) client.TokenSource {
+	return &tokenSource{provider: p, tokens: tokens}
+}
+
+func (s *tokenSource) Token(ctx context.Context) (*client.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tokens.Expiry.IsZero() || s.provider.config.Now().Add(refreshWindow).Before(s.tokens.Expiry) {
+		return s.token(), nil
+	}
+
+	tokens, err := s.provider.Refresh(ctx, s.tokens.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	// the refresh token isn't always rotated
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = s.tokens.RefreshToken
+	}
+	if tokens.IDTokenClaims == nil {
+		tokens.IDTokenClaims = s.tokens.IDTokenClaims
+	}
+	s.tokens = tokens
+
+	return s.token(), nil
+}
+
+func (s *tokenSource) token() *client.Token {
+	return &client.Token{
+		AccessToken: s.tokens.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      s.tokens.Expiry,
+	}
+}
@@ -1,12 +1,22 @@
 package client
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/welldigital/nhs-fhir/jwks"
 )
 
 func TestAccessTokenResponse_ExpiryTime(t *testing.T) {
@@ -164,3 +174,175 @@ func TestAuthConfigOptions_Validate(t *testing.T) {
 		})
 	}
 }
+
+// fakeClock a clock which only moves when told to
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newPrivateKeyPEM(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldnt generate key: %v", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestClient_getAccessToken_freshAssertion(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2021, 12, 8, 9, 0, 0, 0, time.UTC)}
+	key, keyPEM := newPrivateKeyPEM(t)
+
+	var mu sync.Mutex
+	jtis := map[string]bool{}
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := clock.Now()
+		claims := &jwt.StandardClaims{}
+		_, err := jwt.ParseWithClaims(r.FormValue("client_assertion"), claims, func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		// the library validates exp against the real time so check the claims against the fake clock instead
+		var validationErr *jwt.ValidationError
+		if err != nil && !(errors.As(err, &validationErr) && validationErr.Errors == jwt.ValidationErrorExpired) {
+			t.Errorf("client assertion isn't valid: %v", err)
+		}
+		if claims.IssuedAt != now.Unix() {
+			t.Errorf("expected iat to be %v got %v", now.Unix(), claims.IssuedAt)
+		}
+		if claims.ExpiresAt != now.Add(time.Minute).Unix() {
+			t.Errorf("expected exp to be %v got %v", now.Add(time.Minute).Unix(), claims.ExpiresAt)
+		}
+
+		mu.Lock()
+		if jtis[claims.Id] {
+			t.Errorf("jti %v has been reused", claims.Id)
+		}
+		jtis[claims.Id] = true
+		mu.Unlock()
+
+		fmt.Fprintf(w, `{"access_token":"%v","expires_in":"599","token_type":"Bearer","issued_at":"%d"}`,
+			claims.Id, now.UnixNano()/int64(time.Millisecond))
+	}))
+	defer svr.Close()
+
+	c, err := NewClientWithOptions(&Options{
+		Client: svr.Client(),
+		AuthConfigOptions: &AuthConfigOptions{
+			BaseURL:           svr.URL,
+			ClientID:          "123",
+			Kid:               "test",
+			PrivateKey:        keyPEM,
+			AssertionLifetime: time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+	c.now = clock.Now
+
+	// tokens last 10 minutes so this refreshes the token 24 times over 4 hours
+	for i := 0; i < 4*60; i++ {
+		if _, err := c.getAccessToken(context.Background()); err != nil {
+			t.Fatalf("couldnt get access token after %v: %v", clock.Now(), err)
+		}
+		clock.Add(time.Minute)
+	}
+
+	if len(jtis) != 24 {
+		t.Errorf("expected 24 token requests got %v", len(jtis))
+	}
+}
+
+func TestGenerateSecret_keyRing(t *testing.T) {
+	rotation := time.Date(2022, 1, 10, 9, 0, 0, 0, time.UTC)
+	oldKey, _ := newPrivateKeyPEM(t)
+	newKey, _ := newPrivateKeyPEM(t)
+	ring, err := jwks.NewKeyRing(
+		jwks.RingKey{Kid: "old", PrivateKey: oldKey, NotAfter: rotation},
+		jwks.RingKey{Kid: "new", PrivateKey: newKey, NotBefore: rotation},
+	)
+	if err != nil {
+		t.Fatalf("couldnt create key ring: %v", err)
+	}
+	config := AuthConfigOptions{BaseURL: "https://int.api.service.nhs.uk", ClientID: "123", KeyRing: ring}
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		wantKid string
+		wantKey *rsa.PrivateKey
+	}{
+		{name: "before rotation", now: rotation.Add(-time.Minute), wantKid: "old", wantKey: oldKey},
+		{name: "after rotation", now: rotation, wantKid: "new", wantKey: newKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := generateSecret(config, tt.now)
+			if err != nil {
+				t.Fatalf("generateSecret() error = %v", err)
+			}
+			parser := &jwt.Parser{SkipClaimsValidation: true}
+			token, err := parser.Parse(*secret, func(token *jwt.Token) (interface{}, error) {
+				return &tt.wantKey.PublicKey, nil
+			})
+			if err != nil {
+				t.Fatalf("jwt isn't signed by the active key: %v", err)
+			}
+			if token.Header["kid"] != tt.wantKid {
+				t.Errorf("expected kid %v got %v", tt.wantKid, token.Header["kid"])
+			}
+		})
+	}
+}
+
+func TestGenerateSecret_keyRingAlg(t *testing.T) {
+	key, _ := newPrivateKeyPEM(t)
+	ring, err := jwks.NewKeyRing(jwks.RingKey{Kid: "test-1", PrivateKey: struct{ *rsa.PrivateKey }{key}, Alg: "RS256"})
+	if err != nil {
+		t.Fatalf("couldnt create key ring: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		method  jwt.SigningMethod
+		wantErr error
+	}{
+		{name: "the alg of the key is used"},
+		{name: "the method matches the key", method: jwt.SigningMethodRS256},
+		{name: "the method doesn't match the key", method: jwt.SigningMethodRS512, wantErr: ErrInvalidSigningMethodAlg},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := AuthConfigOptions{BaseURL: "https://int.api.service.nhs.uk", ClientID: "123", KeyRing: ring, SigningMethod: tt.method}
+			secret, err := generateSecret(config, time.Now())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("generateSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			token, err := jwt.Parse(*secret, func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			if err != nil {
+				t.Fatalf("jwt isn't signed by the key: %v", err)
+			}
+			if token.Header["alg"] != "RS256" {
+				t.Errorf("expected alg RS256 got %v", token.Header["alg"])
+			}
+		})
+	}
+}
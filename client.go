@@ -38,45 +38,66 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/google/go-querystring/query"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Client manages communication with the NHS FHIR API.
 type Client struct {
 	BaseURL    *url.URL
 	UserAgent  string
-	withAuth   bool
 	httpClient *http.Client
 
 	Patient *PatientService
 
-	accessToken   AccessTokenResponse
-	jwt           string
+	// tokenSource provides the access tokens, requests are sent without auth when nil
+	tokenSource TokenSource
+	// now returns the current time, can be replaced in tests
+	now func() time.Time
+
 	authConfig    *AuthConfigOptions
 	tracingConfig *TracingOptions
+	pollingConfig *PollingOptions
+	retryPolicy   *RetryPolicy
+	limiter       Limiter
+
+	accessMode  AccessMode
+	sessionURID string
+	// nhsLoginClaims the claims of the ID token of the patient logged in with NHS login, only set for PatientAccess
+	nhsLoginClaims *NHSLoginClaims
+
+	// authDisabled requests are sent without an access token, this is set for the sandbox
+	authDisabled bool
+	// logger logs structured events, nil when logging isn't configured
+	logger *eventLogger
+	// telemetry creates OpenTelemetry spans, nil uses the global otel tracer provider
+	telemetry *telemetry
+	// metrics records measurements, nil when metrics aren't configured
+	metrics *metricsRecorder
 }
 
 //go:generate moq -out client_moq.go . IClient
 // IClient interface for Client
 type IClient interface {
-	newRequest(method, path string, body interface{}) (*http.Request, error)
+	newRequest(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error)
 	do(ctx context.Context, req *http.Request, v interface{}) (*Response, error)
 	postForm(ctx context.Context, url string, data url.Values, v interface{}) (*Response, error)
 	baseURLGetter() *url.URL
+	accessGetter() access
 	dumpHTTP(req *http.Request, resp *http.Response) error
 }
 
 var errNonNilContext = errors.New("context must be non-nil")
 
+var errBodyNotRewindable = errors.New("request body can not be sent again")
+
 const (
 	sandboxURL     = "https://sandbox.api.service.nhs.uk/"
 	defaultBaseURL = sandboxURL
@@ -92,6 +113,15 @@ func NewClientWithOptions(opts *Options) (*Client, error) {
 		return NewClient(nil), nil
 	}
 
+	opts, err := applyEnvironment(*opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger = newEventLogger(opts.LoggingOptions)
+	c.telemetry = newTelemetry(opts.TelemetryOptions, opts.Environment)
+	c.metrics = newMetricsRecorder(opts.Metrics)
+
 	if opts.Client != nil {
 		c.httpClient = opts.Client
 	} else {
@@ -99,15 +129,56 @@ func NewClientWithOptions(opts *Options) (*Client, error) {
 	}
 	c.UserAgent = opts.UserAgent
 
+	c.accessMode = opts.AccessMode
+	if c.accessMode == "" {
+		c.accessMode = ApplicationRestricted
+	}
+	claims, err := c.accessMode.validate(opts)
+	if err != nil {
+		return nil, err
+	}
+	c.nhsLoginClaims = claims
+	if opts.HealthcareWorkerOptions != nil && c.accessMode == HealthcareWorker {
+		c.sessionURID = opts.HealthcareWorkerOptions.RoleID
+	}
+
 	if opts.AuthConfigOptions != nil {
-		c.withAuth = true
 		c.authConfig = opts.AuthConfigOptions
+		c.tokenSource = newJWTTokenSource(*opts.AuthConfigOptions, opts.Environment, c)
+	}
+
+	if c.accessMode == PatientAccess {
+		if opts.AuthConfigOptions != nil {
+			c.tokenSource = newNHSLoginTokenSource(*opts.AuthConfigOptions, opts.PatientAccessOptions.IDToken, c)
+		}
+	}
+
+	if opts.TokenSource != nil {
+		c.tokenSource = opts.TokenSource
 	}
 
 	if opts.TracingOptions != nil {
 		c.tracingConfig = opts.TracingOptions
 	}
 
+	if opts.PollingOptions != nil {
+		c.pollingConfig = opts.PollingOptions
+	}
+
+	if opts.RetryPolicy != nil {
+		c.retryPolicy = opts.RetryPolicy
+	}
+
+	if opts.Limiter != nil {
+		c.limiter = opts.Limiter
+	} else if opts.RateLimit != nil {
+		limiter, err := NewLimiter(*opts.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		c.limiter = limiter
+	}
+
 	if opts.BaseURL != "" {
 		baseURL, err := url.Parse(opts.BaseURL)
 		if err != nil {
@@ -117,8 +188,9 @@ func NewClientWithOptions(opts *Options) (*Client, error) {
 	} else {
 		c.BaseURL = newDefaultBaseURL()
 	}
+	c.authDisabled = opts.DisableAuth || !opts.Environment.requiresAuth(c.BaseURL)
 
-	patientService := PatientService{client: c}
+	patientService := PatientService{client: c, telemetry: c.telemetry, metrics: c.metrics}
 	c.Patient = &patientService
 
 	return c, nil
@@ -135,6 +207,8 @@ func NewClient(httpClient *http.Client) *Client {
 	baseURL := newDefaultBaseURL()
 
 	c.BaseURL = baseURL
+	// sandbox doesnt have auth
+	c.authDisabled = true
 
 	patientService := PatientService{client: c}
 	c.Patient = &patientService
@@ -150,58 +224,76 @@ func redactFieldFromHeader(header *http.Header, field string) {
 	}
 }
 
-// dumpHTTP - logs both the request and response to the clients tracing output (defaults to std out).
-// additionally it scrubs the sensitive authorization bearer token from the output.
+// dumpHTTP - captures a copy of the request and response and gives it to the clients tracer, which writes to the tracing output (defaults to std out).
+// Patient data and the authorization bearer token are redacted from the copy. The response body is read in full and replaced so it can still be decoded.
+// req can be nil in which case the request the response was sent for is used.
 func (c *Client) dumpHTTP(req *http.Request, resp *http.Response) error {
-	writer := c.getTraceOutputWriter()
-
-	_, err := fmt.Fprintln(writer, "||------------ BEGIN TRACE ------------||")
-	if err != nil {
-		return err
+	var redaction *RedactionPolicy
+	if c.tracingConfig != nil {
+		redaction = c.tracingConfig.Redaction
 	}
-	hasBody := resp.StatusCode == http.StatusNoContent && resp.StatusCode != http.StatusOK
+	r := newRedactor(redaction)
 
-	// dump client outgoing request
-	redactFieldFromHeader(&req.Header, "Authorization")
-	dumpReq, err := httputil.DumpRequestOut(req, hasBody)
-
-	if err != nil {
-		return err
+	if req == nil && resp != nil {
+		req = resp.Request
 	}
 
-	_, err = fmt.Fprint(writer, string(dumpReq))
-	if err != nil {
-		return err
+	trace := &Trace{Time: c.clock()}
+	var err error
+	if req != nil {
+		if trace.Request, err = captureRequest(req, r); err != nil {
+			return err
+		}
 	}
-
-	// dump response
-	var dumpResp []byte
-	dumpResp, err = httputil.DumpResponse(resp, hasBody)
-	if err != nil {
-		return err
+	if resp != nil {
+		if trace.Response, err = captureResponse(resp, r); err != nil {
+			return err
+		}
 	}
 
-	_, err = fmt.Fprint(writer, strings.TrimSuffix(string(dumpResp), "\r\n"))
-	if err != nil {
-		return err
-	}
+	return c.getTracer().Trace(trace)
+}
 
-	_, err = fmt.Fprintln(writer, "||------------ END TRACE ------------||")
-	if err != nil {
-		return err
+// requestOption customises a request created by newRequest e.g. to set extra headers
+type requestOption func(req *http.Request)
+
+// withContentType overrides the default application/json content type of the request body
+func withContentType(contentType string) requestOption {
+	return func(req *http.Request) {
+		req.Header.Set("Content-Type", contentType)
 	}
+}
 
-	return nil
+// withURL sends the request to the given absolute url instead of a path relative to the BaseURL
+func withURL(u *url.URL) requestOption {
+	return func(req *http.Request) {
+		req.URL = u
+		req.Host = u.Host
+	}
+}
 
+// withHeader sets an additional header on the request
+func withHeader(key, value string) requestOption {
+	return func(req *http.Request) {
+		req.Header.Set(key, value)
+	}
 }
 
 // NewRequest creates an API request. A relative URL can be provided in path,
 // in which case it is resolved relative to the BaseURL of the Client.
 // Relative URLs should always be specified without a preceding slash. If
 // specified, the value pointed to by body is JSON encoded and included as the
-// request body.
-func (c *Client) newRequest(method, path string, body interface{}) (*http.Request, error) {
-	rel := &url.URL{Path: path}
+// request body. Any opts are applied after the default headers have been set.
+// The context is used when getting the access token and is attached to the request.
+func (c *Client) newRequest(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
+	if ctx == nil {
+		return nil, errNonNilContext
+	}
+	// parsed so a query given with the path is sent as the query rather than escaped into the path
+	rel, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
 	u := c.baseURLGetter().ResolveReference(rel)
 	var buf io.ReadWriter
 	if body != nil {
@@ -211,7 +303,7 @@ func (c *Client) newRequest(method, path string, body interface{}) (*http.Reques
 			return nil, err
 		}
 	}
-	req, err := http.NewRequest(method, u.String(), buf)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), buf)
 	if err != nil {
 		return nil, err
 	}
@@ -222,11 +314,28 @@ func (c *Client) newRequest(method, path string, body interface{}) (*http.Reques
 	req.Header.Set("User-Agent", c.UserAgent)
 	// Every request to NHS API should contain a unique id otherwise we receive a 429
 	req.Header.Set("X-Request-ID", uuid.New().String())
+	// the trace id lets NHS support find every request made for the trace
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		req.Header.Set("X-Correlation-ID", span.TraceID().String())
+	}
 
-	// sandbox doesnt have auth
-	if c.withAuth && c.baseURLGetter().String() != sandboxURL {
-		bearerToken, err := c.getAccessToken(context.Background())
+	if c.sessionURID != "" {
+		req.Header.Set(sessionURIDHeader, c.sessionURID)
+	}
+
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	if c.tokenSource != nil && !c.authDisabled {
+		// postForm sets this to false when a new token has to be requested
+		clientSpan(ctx).SetAttributes(AttrTokenFromCache.Bool(true))
+		bearerToken, err := c.getAccessToken(ctx)
 		if err != nil {
+			// use the error stored in context as likely to be more informative
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			return nil, err
 		}
 		req.Header.Set("Authorization", "Bearer "+bearerToken)
@@ -238,44 +347,137 @@ func (c *Client) newRequest(method, path string, body interface{}) (*http.Reques
 // Do sends an API request and returns the API response. The API response is
 // JSON decoded and stored in the value pointed to by v, or returned as an
 // error if an API error has occurred.
+// Failed requests are retried according to the clients RetryPolicy.
+// If the API responds with 202 Accepted then the result is polled for until it's ready,
+// unless manual polling is configured in which case ErrAccepted is returned along with a Poller on the response.
 func (c *Client) do(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 	if ctx == nil {
 		return nil, errNonNilContext
 	}
+	req, resp, stats, err := c.sendWithRetry(ctx, req)
+	recordAttempts(clientSpan(ctx), req, resp, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		resp.Body.Close()
+		poller, err := c.newPoller(resp)
+		r := newResponse(resp)
+		r.RequestID = req.Header.Get("X-Request-ID")
+		stats.apply(r)
+		if err != nil {
+			return r, err
+		}
+		if c.pollingConfig != nil && c.pollingConfig.Manual {
+			r.Poller = poller
+			return r, ErrAccepted
+		}
+		return poller.Wait(ctx, v)
+	}
+
+	defer resp.Body.Close()
+	r, err := c.decode(req, resp, v)
+
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		rateLimitErr.Attempts = stats.attempts
+	}
+	if r != nil {
+		stats.apply(r)
+	}
+
+	return r, err
+}
+
+// sendStats the statistics collected while sending a request
+type sendStats struct {
+	// attempts the number of times the request was sent
+	attempts int
+	// rateLimitWait the time spent waiting on the client side rate limiter
+	rateLimitWait time.Duration
+}
+
+// apply copies the stats onto the response
+func (s *sendStats) apply(r *Response) {
+	r.Attempts = s.attempts
+	r.RateLimitWait = s.rateLimitWait
+}
+
+// send waits for the rate limiter then sends the request using the underlying http client,
+// the request and response are traced if enabled
+func (c *Client) send(ctx context.Context, req *http.Request, stats *sendStats) (*http.Response, error) {
+	wait, err := c.waitForLimiter(ctx)
+	stats.rateLimitWait += wait
+	if err != nil {
+		return nil, err
+	}
+	stats.attempts++
+
+	fields := requestFields(req.Method, req.URL, req.Header.Get("X-Request-ID"), stats.attempts)
+	c.log(ctx, EventRequestStart, fields...)
+	start := c.clock()
+
 	req = req.WithContext(ctx)
+	c.telemetry.inject(ctx, req.Header)
 	resp, err := c.httpClientGetter().Do(req)
+	latency := c.clock().Sub(start)
+	c.metrics.request(ctx, resp, latency)
 
 	// use the error stored in context as likely to be more informative
 	if err != nil {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			err = ctx.Err()
 		default:
 		}
 
+		c.log(ctx, EventRequestError, append(fields, Field{Key: "latency", Value: latency}, Field{Key: "error", Value: err})...)
 		return nil, err
 	}
-	defer resp.Body.Close()
+	c.log(ctx, EventRequestEnd, append(fields, Field{Key: "status", Value: resp.StatusCode}, Field{Key: "latency", Value: latency})...)
 
 	if c.tracingConfig != nil && c.tracingConfig.Enabled && !(c.tracingConfig.TraceErrorsOnly && resp.StatusCode == http.StatusOK) {
 
 		if err := c.dumpHTTP(req, resp); err != nil {
+			resp.Body.Close()
 			return nil, err
 		}
 	}
 
-	if resp.StatusCode == 429 {
-		return nil, &RateLimitError{}
-	}
+	return resp, nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(v)
+// decode decodes the body of the response into v.
+// Any unsuccessful response is returned as an OperationOutcomeError.
+func (c *Client) decode(req *http.Request, resp *http.Response, v interface{}) (*Response, error) {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &RateLimitError{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Attempts:   1,
+		}
+	}
 
 	r := newResponse(resp)
 	r.RequestID = req.Header.Get("X-Request-ID")
 
+	if !isSuccess(resp.StatusCode) {
+		return r, newOperationOutcomeError(r)
+	}
+
+	err := json.NewDecoder(resp.Body).Decode(v)
+
 	return r, err
 }
 
+// getTracer returns the configured tracer or a text tracer writing to the trace output
+func (c *Client) getTracer() Tracer {
+	if c.tracingConfig != nil && c.tracingConfig.Tracer != nil {
+		return c.tracingConfig.Tracer
+	}
+	return NewTextTracer(c.getTraceOutputWriter())
+}
+
 func (c *Client) getTraceOutputWriter() io.Writer {
 	if c.tracingConfig == nil || c.tracingConfig.Output == nil {
 		return os.Stdout
@@ -283,86 +485,88 @@ func (c *Client) getTraceOutputWriter() io.Writer {
 	return c.tracingConfig.Output
 }
 
-// getAccessToken return a valid access token
-// we check if the access token is valid and if not then we generate a new one
-// note: the nhs fhir api does not currently provide us with a way to get a refresh token
-// instead it's advised to grab a new access token
-// https://digital.nhs.uk/developer/guides-and-documentation/security-and-authorisation/application-restricted-restful-apis-signed-jwt-authentication#step-8-refresh-token
+// getAccessToken returns a valid access token from the clients token source
 func (c *Client) getAccessToken(ctx context.Context) (string, error) {
-	if c.accessToken.AccessToken != "" && !c.accessToken.HasExpired() {
-		return c.accessToken.AccessToken, nil
-	}
-	if c.jwt == "" {
-		jwt, err := generateSecret(*c.authConfig)
-		if err != nil {
-			return c.accessToken.AccessToken, err
-		}
-		c.jwt = *jwt
-	}
-	token, _, err := c.generateAccessToken(ctx, c.jwt)
+	token, err := c.tokenSource.Token(ctx)
 	if err != nil {
 		return "", err
 	}
-	return token.AccessToken, err
+	c.metrics.tokenAge(token, c.clock())
+	return token.AccessToken, nil
 }
 
-func (c *Client) postForm(ctx context.Context, url string, data url.Values, v interface{}) (*Response, error) {
-	resp, err := c.httpClientGetter().PostForm(url, data)
+// postForm posts the url encoded data to the token endpoint at url and decodes the response into v.
+// An unsuccessful response is returned as a TokenError. The request is cancelled when the context is done.
+// The request is made in an nhs.oauth.token span.
+func (c *Client) postForm(ctx context.Context, url string, data url.Values, v interface{}) (res *Response, err error) {
+	if ctx == nil {
+		return nil, errNonNilContext
+	}
+	clientSpan(ctx).SetAttributes(AttrTokenFromCache.Bool(false))
+	ctx, span := c.telemetry.start(ctx, SpanOAuthToken)
+	defer func() { endSpan(span, err) }()
+
+	wait, err := c.waitForLimiter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.telemetry.inject(ctx, req.Header)
+
+	fields := requestFields(req.Method, req.URL, "", 1)
+	c.log(ctx, EventRequestStart, fields...)
+	start := c.clock()
+	resp, err := c.httpClientGetter().Do(req)
+	latency := c.clock().Sub(start)
+	c.metrics.request(ctx, resp, latency)
 
 	// use the error stored in context as likely to be more informative
 	if err != nil {
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			err = ctx.Err()
 		default:
 		}
 
+		c.log(ctx, EventRequestError, append(fields, Field{Key: "latency", Value: latency}, Field{Key: "error", Value: err})...)
 		return nil, err
 	}
+	c.log(ctx, EventRequestEnd, append(fields, Field{Key: "status", Value: resp.StatusCode}, Field{Key: "latency", Value: latency})...)
 
 	defer resp.Body.Close()
+	span.SetAttributes(AttrStatusCode.Int(resp.StatusCode))
 
 	if c.tracingConfig != nil && c.tracingConfig.Enabled && !(c.tracingConfig.TraceErrorsOnly && resp.StatusCode == http.StatusOK) {
 
-		if err := c.dumpHTTP(nil, resp); err != nil {
+		if err := c.dumpHTTP(req, resp); err != nil {
 			return nil, err
 		}
 	}
 
-	if resp.StatusCode == 429 {
-		return nil, &RateLimitError{}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &RateLimitError{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Attempts:   1,
+		}
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(v)
-
-	return newResponse(resp), err
-
-}
-
-// GenerateToken gets the access token using a signed token
-func (c *Client) generateAccessToken(ctx context.Context, jwt string) (*AccessTokenResponse, *Response, error) {
-
-	path := "/oauth2/token"
+	r := newResponse(resp)
+	r.Attempts = 1
+	r.RateLimitWait = wait
 
-	opts := AccessTokenRequest{
-		GrantType:           "client_credentials",
-		ClientAssertionType: "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
-		JWT:                 jwt,
+	if !isSuccess(resp.StatusCode) {
+		return r, newTokenError(resp)
 	}
 
-	data, err := query.Values(opts)
-	if err != nil {
-		return nil, nil, err
-	}
-	tokenRes := &AccessTokenResponse{}
+	err = json.NewDecoder(resp.Body).Decode(v)
 
-	res, err := c.postForm(ctx, c.authConfig.BaseURL+path, data, tokenRes)
+	return r, err
 
-	if err != nil {
-		return nil, res, fmt.Errorf("error generating access token: %v", err)
-	}
-	c.accessToken = *tokenRes
-	return tokenRes, res, err
 }
 
 // baseURL retrieves a baseURL, if not set then we return the default url
@@ -373,6 +577,31 @@ func (c *Client) baseURLGetter() *url.URL {
 	return c.BaseURL
 }
 
+// accessGetter returns the access mode of the client, defaults to ApplicationRestricted
+func (c *Client) accessGetter() access {
+	a := access{mode: c.accessMode}
+	if c.nhsLoginClaims != nil {
+		a.nhsNumber = c.nhsLoginClaims.NHSNumber
+	}
+	if a.mode == "" {
+		a.mode = ApplicationRestricted
+	}
+	return a
+}
+
+// log logs the event if the client has a logger
+func (c *Client) log(ctx context.Context, event LogEvent, fields ...Field) {
+	c.logger.log(ctx, event, fields...)
+}
+
+// clock returns the current time
+func (c *Client) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
 // httpClientGetter provides a way to get the underlying http client
 // if the client was initialized using a struct then this guarantees that the behaviour will be normal
 func (c *Client) httpClientGetter() *http.Client {
@@ -20,6 +20,9 @@ var _ IClient = &IClientMock{}
 //
 // 		// make and configure a mocked IClient
 // 		mockedIClient := &IClientMock{
+// 			accessGetterFunc: func() access {
+// 				panic("mock out the accessGetter method")
+// 			},
 // 			baseURLGetterFunc: func() *url.URL {
 // 				panic("mock out the baseURLGetter method")
 // 			},
@@ -29,7 +32,7 @@ var _ IClient = &IClientMock{}
 // 			dumpHTTPFunc: func(req *http.Request, resp *http.Response) error {
 // 				panic("mock out the dumpHTTP method")
 // 			},
-// 			newRequestFunc: func(method string, path string, body interface{}) (*http.Request, error) {
+// 			newRequestFunc: func(ctx context.Context, method string, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 // 				panic("mock out the newRequest method")
 // 			},
 // 			postFormFunc: func(ctx context.Context, urlMoqParam string, data url.Values, v interface{}) (*Response, error) {
@@ -42,6 +45,9 @@ var _ IClient = &IClientMock{}
 //
 // 	}
 type IClientMock struct {
+	// accessGetterFunc mocks the accessGetter method.
+	accessGetterFunc func() access
+
 	// baseURLGetterFunc mocks the baseURLGetter method.
 	baseURLGetterFunc func() *url.URL
 
@@ -52,13 +58,16 @@ type IClientMock struct {
 	dumpHTTPFunc func(req *http.Request, resp *http.Response) error
 
 	// newRequestFunc mocks the newRequest method.
-	newRequestFunc func(method string, path string, body interface{}) (*http.Request, error)
+	newRequestFunc func(ctx context.Context, method string, path string, body interface{}, opts ...requestOption) (*http.Request, error)
 
 	// postFormFunc mocks the postForm method.
 	postFormFunc func(ctx context.Context, urlMoqParam string, data url.Values, v interface{}) (*Response, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// accessGetter holds details about calls to the accessGetter method.
+		accessGetter []struct {
+		}
 		// baseURLGetter holds details about calls to the baseURLGetter method.
 		baseURLGetter []struct {
 		}
@@ -80,12 +89,16 @@ type IClientMock struct {
 		}
 		// newRequest holds details about calls to the newRequest method.
 		newRequest []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
 			// Method is the method argument value.
 			Method string
 			// Path is the path argument value.
 			Path string
 			// Body is the body argument value.
 			Body interface{}
+			// Opts is the opts argument value.
+			Opts []requestOption
 		}
 		// postForm holds details about calls to the postForm method.
 		postForm []struct {
@@ -99,6 +112,7 @@ type IClientMock struct {
 			V interface{}
 		}
 	}
+	lockaccessGetter  sync.RWMutex
 	lockbaseURLGetter sync.RWMutex
 	lockdo            sync.RWMutex
 	lockdumpHTTP      sync.RWMutex
@@ -106,6 +120,32 @@ type IClientMock struct {
 	lockpostForm      sync.RWMutex
 }
 
+// accessGetter calls accessGetterFunc.
+func (mock *IClientMock) accessGetter() access {
+	if mock.accessGetterFunc == nil {
+		panic("IClientMock.accessGetterFunc: method is nil but IClient.accessGetter was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockaccessGetter.Lock()
+	mock.calls.accessGetter = append(mock.calls.accessGetter, callInfo)
+	mock.lockaccessGetter.Unlock()
+	return mock.accessGetterFunc()
+}
+
+// accessGetterCalls gets all the calls that were made to accessGetter.
+// Check the length with:
+//     len(mockedIClient.accessGetterCalls())
+func (mock *IClientMock) accessGetterCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockaccessGetter.RLock()
+	calls = mock.calls.accessGetter
+	mock.lockaccessGetter.RUnlock()
+	return calls
+}
+
 // baseURLGetter calls baseURLGetterFunc.
 func (mock *IClientMock) baseURLGetter() *url.URL {
 	if mock.baseURLGetterFunc == nil {
@@ -207,37 +247,45 @@ func (mock *IClientMock) dumpHTTPCalls() []struct {
 }
 
 // newRequest calls newRequestFunc.
-func (mock *IClientMock) newRequest(method string, path string, body interface{}) (*http.Request, error) {
+func (mock *IClientMock) newRequest(ctx context.Context, method string, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 	if mock.newRequestFunc == nil {
 		panic("IClientMock.newRequestFunc: method is nil but IClient.newRequest was just called")
 	}
 	callInfo := struct {
+		Ctx    context.Context
 		Method string
 		Path   string
 		Body   interface{}
+		Opts   []requestOption
 	}{
+		Ctx:    ctx,
 		Method: method,
 		Path:   path,
 		Body:   body,
+		Opts:   opts,
 	}
 	mock.locknewRequest.Lock()
 	mock.calls.newRequest = append(mock.calls.newRequest, callInfo)
 	mock.locknewRequest.Unlock()
-	return mock.newRequestFunc(method, path, body)
+	return mock.newRequestFunc(ctx, method, path, body, opts...)
 }
 
 // newRequestCalls gets all the calls that were made to newRequest.
 // Check the length with:
 //     len(mockedIClient.newRequestCalls())
 func (mock *IClientMock) newRequestCalls() []struct {
+	Ctx    context.Context
 	Method string
 	Path   string
 	Body   interface{}
+	Opts   []requestOption
 } {
 	var calls []struct {
+		Ctx    context.Context
 		Method string
 		Path   string
 		Body   interface{}
+		Opts   []requestOption
 	}
 	mock.locknewRequest.RLock()
 	calls = mock.calls.newRequest
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,8 +12,12 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -110,7 +115,7 @@ func TestDo_sanitizeURL(t *testing.T) {
 	}
 	unauthedClient := NewClient(tp.Client)
 	unauthedClient.BaseURL = &url.URL{Scheme: "http", Host: "127.0.0.1:0", Path: "/"} // Use port 0 on purpose to trigger a dial TCP error, expect to get "dial tcp 127.0.0.1:0: connect: can't assign requested address".
-	req, err := unauthedClient.newRequest("GET", ".", nil)
+	req, err := unauthedClient.newRequest(context.Background(), "GET", ".", nil)
 	if err != nil {
 		t.Fatalf("newRequest returned unexpected error: %v", err)
 	}
@@ -134,7 +139,7 @@ func TestNewRequest(t *testing.T) {
 
 	inURL, outURL := "foo", defaultBaseURL+"foo"
 	inBody, outBody := &TestBody{Foo: "bar"}, `{"Foo":"bar"}`+"\n"
-	req, _ := c.newRequest("GET", inURL, inBody)
+	req, _ := c.newRequest(context.Background(), "GET", inURL, inBody)
 
 	// test that relative URL was expanded
 	if got, want := req.URL.String(), outURL; got != want {
@@ -153,7 +158,7 @@ func TestNewRequest(t *testing.T) {
 	}
 
 	// test that each request contains a unique guid
-	req2, _ := c.newRequest("GET", inURL, inBody)
+	req2, _ := c.newRequest(context.Background(), "GET", inURL, inBody)
 
 	if id1, id2 := req.Header.Get("X-Request-ID"), req2.Header.Get("X-Request-ID"); id1 == id2 {
 		t.Errorf("NewRequest() X-Request-ID ")
@@ -187,7 +192,6 @@ func TestNewClientWithOptions(t *testing.T) {
 			want: &Client{
 				BaseURL:    newDefaultBaseURL(),
 				httpClient: newDefaultHTTPClient(),
-				withAuth:   false,
 			},
 			wantErr: false,
 		},
@@ -215,7 +219,7 @@ func TestNewClientWithOptions(t *testing.T) {
 				},
 			},
 			want: &Client{
-				withAuth: true,
+				tokenSource: &reuseTokenSource{},
 				authConfig: &AuthConfigOptions{
 					BaseURL:           urlStr,
 					ClientID:          "123",
@@ -242,18 +246,8 @@ func TestNewClientWithOptions(t *testing.T) {
 }
 
 func isClientEqual(got *Client, want *Client) bool {
-	if got.accessToken != want.accessToken {
-		fmt.Println("access token not equal")
-		return false
-	}
-
-	if got.jwt != want.jwt {
-		fmt.Println("jwt not equal")
-		return false
-	}
-
-	if got.withAuth != want.withAuth {
-		fmt.Println("withAuth not equal")
+	if reflect.TypeOf(got.tokenSource) != reflect.TypeOf(want.tokenSource) {
+		fmt.Println("token source not equal")
 		return false
 	}
 	if !reflect.DeepEqual(&got.authConfig, &want.authConfig) {
@@ -393,3 +387,191 @@ func TestClient_dumpHTTP(t *testing.T) {
 		})
 	}
 }
+
+func TestNewRequest_options(t *testing.T) {
+	c := NewClient(nil)
+
+	req, err := c.newRequest(context.Background(), http.MethodPatch, "foo", struct{}{},
+		withContentType("application/json-patch+json"),
+		withHeader("If-Match", `W/"1"`),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json-patch+json", req.Header.Get("Content-Type"))
+	assert.Equal(t, `W/"1"`, req.Header.Get("If-Match"))
+	assert.Equal(t, "application/json", req.Header.Get("Accept"))
+}
+
+// newAuthServer returns a server which issues access tokens on /oauth2/token and responds with an empty patient
+// on every other path as long as the request contains a token it issued
+func newAuthServer(t *testing.T, expiresIn int, tokenRequests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/token" {
+			n := atomic.AddInt32(tokenRequests, 1)
+			// slow token endpoint so that concurrent callers overlap
+			time.Sleep(20 * time.Millisecond)
+			fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":"%d","token_type":"Bearer","issued_at":"%d"}`,
+				n, expiresIn, time.Now().UnixNano()/int64(time.Millisecond))
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			t.Errorf("expected request to contain an access token got %q", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`{"resourceType":"Patient"}`))
+	}))
+}
+
+// stubAuthConfig the auth config of a test client, the JWT isn't really signed so NHS auth must be stubbed at baseURL
+func stubAuthConfig(baseURL string) *AuthConfigOptions {
+	return &AuthConfigOptions{
+		BaseURL:  baseURL,
+		ClientID: "123",
+		Kid:      "test",
+		Signer: func(token *jwt.Token, key interface{}) (string, error) {
+			return "signed-jwt", nil
+		},
+	}
+}
+
+// newTestClient creates a client which calls svr as a custom environment, the rest of the options are taken from opts.
+// Access tokens are requested from svr using stubAuthConfig unless opts has its own auth or disables it.
+func newTestClient(t *testing.T, svr *httptest.Server, opts *Options) *Client {
+	t.Helper()
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.Client = svr.Client()
+	o.BaseURL = svr.URL + "/"
+	o.Environment = Custom
+	if o.AuthConfigOptions == nil && o.TokenSource == nil && !o.DisableAuth {
+		o.AuthConfigOptions = stubAuthConfig(svr.URL)
+	}
+	c, err := NewClientWithOptions(&o)
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+	return c
+}
+
+func TestClient_getAccessToken_concurrent(t *testing.T) {
+	var tokenRequests int32
+	svr := newAuthServer(t, 599, &tokenRequests)
+	defer svr.Close()
+
+	c := newTestClient(t, svr, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, _, err := c.Patient.Get(context.Background(), "9000000009"); err != nil {
+					t.Errorf("expected err to be nil got %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenRequests), "only one token should be requested")
+}
+
+func TestClient_getAccessToken_refreshWindow(t *testing.T) {
+	var tokenRequests int32
+	svr := newAuthServer(t, 60, &tokenRequests)
+	defer svr.Close()
+
+	// the token expires within the refresh window so it's refreshed on every call
+	auth := stubAuthConfig(svr.URL)
+	auth.RefreshWindow = 2 * time.Minute
+	c := newTestClient(t, svr, &Options{AuthConfigOptions: auth})
+	token1, err := c.getAccessToken(context.Background())
+	assert.NoError(t, err)
+	token2, err := c.getAccessToken(context.Background())
+	assert.NoError(t, err)
+	assert.NotEqual(t, token1, token2)
+
+	// the token is reused when it's outside the refresh window
+	auth = stubAuthConfig(svr.URL)
+	auth.RefreshWindow = 30 * time.Second
+	c = newTestClient(t, svr, &Options{AuthConfigOptions: auth})
+	token1, _ = c.getAccessToken(context.Background())
+	token2, _ = c.getAccessToken(context.Background())
+	assert.Equal(t, token1, token2)
+}
+
+func TestClient_getAccessToken_waiterContextCancelled(t *testing.T) {
+	var tokenRequests int32
+	svr := newAuthServer(t, 599, &tokenRequests)
+	defer svr.Close()
+	c := newTestClient(t, svr, nil)
+
+	go c.getAccessToken(context.Background())
+
+	// wait for the refresh to start
+	source := c.tokenSource.(*reuseTokenSource)
+	assert.Eventually(t, func() bool {
+		source.mu.Lock()
+		defer source.mu.Unlock()
+		return source.refresh != nil
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.getAccessToken(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestClient_tokenRequest_contextCancelled(t *testing.T) {
+	block := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/token" {
+			select {
+			case <-block:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		t.Errorf("expected no request to %v", r.URL.Path)
+	}))
+	defer svr.Close()
+	defer close(block)
+
+	c := newTestClient(t, svr, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := c.Patient.Get(ctx, "9000000009")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v got %v", context.DeadlineExceeded, err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("expected request to be aborted when the context is done, took %v", took)
+	}
+
+	// a cancelled request must not leave a token behind
+	if token := c.tokenSource.(*reuseTokenSource).token; token != nil {
+		t.Errorf("expected no token to be cached got %v", token)
+	}
+}
+
+func TestClient_postForm_contextCancelled(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected no request to be sent")
+	}))
+	defer svr.Close()
+
+	c := NewClient(svr.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.postForm(ctx, svr.URL+"/oauth2/token", url.Values{}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v got %v", context.Canceled, err)
+	}
+}
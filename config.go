@@ -0,0 +1,209 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The environment variables read by NewClientFromEnv
+const (
+	// EnvEnvironment the environment to call, one of sandbox, integration, production or custom
+	EnvEnvironment = "NHS_FHIR_ENVIRONMENT"
+	// EnvBaseURL the url of the API, only needed for the custom environment
+	EnvBaseURL = "NHS_FHIR_BASE_URL"
+	// EnvUserAgent the user agent sent with every request
+	EnvUserAgent = "NHS_FHIR_USER_AGENT"
+	// EnvAuthBaseURL the url of NHS auth, only needed for the custom environment
+	EnvAuthBaseURL = "NHS_FHIR_AUTH_BASE_URL"
+	// EnvClientID the api key of your nhs application
+	EnvClientID = "NHS_FHIR_CLIENT_ID"
+	// EnvKid the key identifier of your private key
+	EnvKid = "NHS_FHIR_KID"
+	// EnvPrivateKeyFile the location of your private RSA key
+	EnvPrivateKeyFile = "NHS_FHIR_PRIVATE_KEY_FILE"
+	// EnvPrivateKey the PEM encoded value of your private RSA key, use this instead of EnvPrivateKeyFile
+	EnvPrivateKey = "NHS_FHIR_PRIVATE_KEY"
+	// EnvTracingEnabled set to true to trace requests and responses
+	EnvTracingEnabled = "NHS_FHIR_TRACING_ENABLED"
+	// EnvTracingErrorsOnly set to true to only trace errors
+	EnvTracingErrorsOnly = "NHS_FHIR_TRACING_ERRORS_ONLY"
+)
+
+// ErrConfigFormat error for when the config file isn't YAML or JSON
+var ErrConfigFormat = errors.New("config file must be .yaml, .yml or .json")
+
+// Config the configuration of a client, it can be loaded from a YAML or JSON file with LoadConfig
+// or from environment variables with NewClientFromEnv.
+type Config struct {
+	// Environment the environment to call. Defaults to sandbox
+	Environment Environment `json:"environment" yaml:"environment"`
+	// BaseURL the url of the API, only needed for the custom environment
+	BaseURL string `json:"baseUrl" yaml:"baseUrl"`
+	// UserAgent the user agent sent with every request
+	UserAgent string         `json:"userAgent" yaml:"userAgent"`
+	Auth      *ConfigAuth    `json:"auth" yaml:"auth"`
+	Tracing   *ConfigTracing `json:"tracing" yaml:"tracing"`
+}
+
+// ConfigAuth the configuration of JWT auth, see AuthConfigOptions
+type ConfigAuth struct {
+	// BaseURL the url of NHS auth, only needed for the custom environment
+	BaseURL string `json:"baseUrl" yaml:"baseUrl"`
+	// ClientID the api key of your nhs application
+	ClientID string `json:"clientId" yaml:"clientId"`
+	// Kid the key identifier of your private key
+	Kid string `json:"kid" yaml:"kid"`
+	// PrivateKeyFile the location of your private RSA key
+	PrivateKeyFile string `json:"privateKeyFile" yaml:"privateKeyFile"`
+	// PrivateKey the PEM encoded value of your private RSA key
+	PrivateKey string `json:"privateKey" yaml:"privateKey"`
+}
+
+// ConfigTracing the configuration of tracing, see TracingOptions
+type ConfigTracing struct {
+	Enabled    bool `json:"enabled" yaml:"enabled"`
+	ErrorsOnly bool `json:"errorsOnly" yaml:"errorsOnly"`
+}
+
+// LoadConfig reads the YAML or JSON config file and returns the options to create a client with.
+// Every missing or invalid field is returned together in the error.
+func LoadConfig(path string) (*Options, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, config)
+	case ".json":
+		err = json.Unmarshal(b, config)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrConfigFormat, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %v: %w", path, err)
+	}
+
+	return config.Options()
+}
+
+// NewClientFromEnv creates a client from the NHS_FHIR_* environment variables e.g. NHS_FHIR_CLIENT_ID.
+// Every missing or invalid variable is returned together in the error.
+func NewClientFromEnv() (*Client, error) {
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithOptions(opts)
+}
+
+// OptionsFromEnv reads the NHS_FHIR_* environment variables into the options to create a client with
+func OptionsFromEnv() (*Options, error) {
+	config := &Config{
+		Environment: Environment(os.Getenv(EnvEnvironment)),
+		BaseURL:     os.Getenv(EnvBaseURL),
+		UserAgent:   os.Getenv(EnvUserAgent),
+	}
+
+	auth := ConfigAuth{
+		BaseURL:        os.Getenv(EnvAuthBaseURL),
+		ClientID:       os.Getenv(EnvClientID),
+		Kid:            os.Getenv(EnvKid),
+		PrivateKeyFile: os.Getenv(EnvPrivateKeyFile),
+		PrivateKey:     os.Getenv(EnvPrivateKey),
+	}
+	if auth != (ConfigAuth{}) {
+		config.Auth = &auth
+	}
+
+	var errs []error
+	tracing := ConfigTracing{}
+	bools := []struct {
+		name  string
+		value *bool
+	}{
+		{EnvTracingEnabled, &tracing.Enabled},
+		{EnvTracingErrorsOnly, &tracing.ErrorsOnly},
+	}
+	for _, b := range bools {
+		s := os.Getenv(b.name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v must be true or false got %q", b.name, s))
+			continue
+		}
+		*b.value = v
+	}
+	if tracing != (ConfigTracing{}) {
+		config.Tracing = &tracing
+	}
+
+	opts, err := config.Options()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return opts, nil
+}
+
+// Options converts the config to the options to create a client with.
+// The auth config is checked with AuthConfigOptions.Validate after the urls of the environment have been applied,
+// every missing or invalid field is returned together in the error.
+func (c *Config) Options() (*Options, error) {
+	opts := &Options{
+		Environment: c.Environment,
+		BaseURL:     c.BaseURL,
+		UserAgent:   c.UserAgent,
+	}
+	if c.Auth == nil && environments[c.Environment].requiresAuth {
+		// validated below so every missing field is listed
+		opts.AuthConfigOptions = &AuthConfigOptions{}
+	}
+	if c.Auth != nil {
+		opts.AuthConfigOptions = &AuthConfigOptions{
+			BaseURL:           c.Auth.BaseURL,
+			ClientID:          c.Auth.ClientID,
+			Kid:               c.Auth.Kid,
+			PrivateKeyPemFile: c.Auth.PrivateKeyFile,
+			PrivateKey:        []byte(c.Auth.PrivateKey),
+		}
+	}
+	if c.Tracing != nil {
+		opts.TracingOptions = &TracingOptions{
+			Enabled:         c.Tracing.Enabled,
+			TraceErrorsOnly: c.Tracing.ErrorsOnly,
+		}
+	}
+
+	// the auth config is still validated when the environment can't be applied so every error is listed
+	var errs []error
+	applied, err := applyEnvironment(*opts)
+	if err != nil {
+		errs = append(errs, err)
+		applied = opts
+	}
+	if applied.AuthConfigOptions != nil {
+		if err := applied.AuthConfigOptions.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+
+	return applied, nil
+}
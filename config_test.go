@@ -0,0 +1,128 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	yamlConfig := `
+environment: integration
+userAgent: my-service
+auth:
+  clientId: "123"
+  kid: test-1
+  privateKeyFile: key.pem
+tracing:
+  enabled: true
+  errorsOnly: true
+`
+	jsonConfig := `{
+	"environment": "integration",
+	"userAgent": "my-service",
+	"auth": {"clientId": "123", "kid": "test-1", "privateKeyFile": "key.pem"},
+	"tracing": {"enabled": true, "errorsOnly": true}
+}`
+
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		wantErrs []error
+	}{
+		{name: "yaml", file: "config.yaml", contents: yamlConfig},
+		{name: "yml", file: "config.yml", contents: yamlConfig},
+		{name: "json", file: "config.json", contents: jsonConfig},
+		{name: "unsupported format", file: "config.toml", contents: "", wantErrs: []error{ErrConfigFormat}},
+		{
+			name:     "lists every missing field",
+			file:     "config.yaml",
+			contents: "environment: production\nauth:\n  clientId: \"123\"\n",
+			wantErrs: []error{ErrKidMissing, ErrKeyMissing},
+		},
+		{
+			name:     "auth missing for an environment which requires it",
+			file:     "config.json",
+			contents: `{"environment": "integration"}`,
+			wantErrs: []error{ErrKidMissing, ErrClientIDMissing, ErrKeyMissing},
+		},
+		{
+			name:     "environment and auth errors together",
+			file:     "config.yaml",
+			contents: "environment: production\nbaseUrl: https://int.api.service.nhs.uk/\nauth:\n  clientId: \"123\"\n",
+			wantErrs: []error{ErrEnvironmentURLConflict, ErrKidMissing, ErrKeyMissing},
+		},
+		{
+			name:     "custom without a base url",
+			file:     "config.json",
+			contents: `{"environment": "custom", "auth": {"baseUrl": "http://localhost:9000", "clientId": "123", "kid": "test", "privateKey": "key"}}`,
+			wantErrs: []error{ErrCustomBaseURLMissing},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.contents), 0600); err != nil {
+				t.Fatalf("couldnt write config: %v", err)
+			}
+
+			opts, err := LoadConfig(path)
+			if len(tt.wantErrs) > 0 {
+				for _, want := range tt.wantErrs {
+					if !errors.Is(err, want) {
+						t.Errorf("LoadConfig() error = %v, want %v", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+
+			assert.Equal(t, "https://int.api.service.nhs.uk/", opts.BaseURL)
+			assert.Equal(t, "my-service", opts.UserAgent)
+			assert.Equal(t, "https://int.api.service.nhs.uk", opts.AuthConfigOptions.BaseURL)
+			assert.Equal(t, "123", opts.AuthConfigOptions.ClientID)
+			assert.Equal(t, "test-1", opts.AuthConfigOptions.Kid)
+			assert.Equal(t, "key.pem", opts.AuthConfigOptions.PrivateKeyPemFile)
+			assert.Equal(t, &TracingOptions{Enabled: true, TraceErrorsOnly: true}, opts.TracingOptions)
+		})
+	}
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	t.Setenv(EnvEnvironment, "integration")
+	t.Setenv(EnvClientID, "123")
+	t.Setenv(EnvKid, "test-1")
+	t.Setenv(EnvPrivateKeyFile, "key.pem")
+	t.Setenv(EnvTracingEnabled, "true")
+
+	c, err := NewClientFromEnv()
+	if err != nil {
+		t.Fatalf("NewClientFromEnv() error = %v", err)
+	}
+	assert.Equal(t, "https://int.api.service.nhs.uk/", c.BaseURL.String())
+	assert.Equal(t, "https://int.api.service.nhs.uk", c.authConfig.BaseURL)
+	assert.Equal(t, "123", c.authConfig.ClientID)
+	assert.True(t, c.tracingConfig.Enabled)
+}
+
+func TestNewClientFromEnv_errors(t *testing.T) {
+	t.Setenv(EnvEnvironment, "production")
+	t.Setenv(EnvTracingEnabled, "yes please")
+
+	_, err := NewClientFromEnv()
+	for _, want := range []error{ErrKidMissing, ErrClientIDMissing, ErrKeyMissing} {
+		if !errors.Is(err, want) {
+			t.Errorf("NewClientFromEnv() error = %v, want %v", err, want)
+		}
+	}
+	if err == nil || !strings.Contains(err.Error(), EnvTracingEnabled) {
+		t.Errorf("expected the error to contain %v got %v", EnvTracingEnabled, err)
+	}
+}
@@ -4,6 +4,7 @@ package e2e
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"testing"
@@ -27,7 +28,8 @@ func TestPatientGet(t *testing.T) {
 		id            string
 		expStatusCode int
 		expGender     string
-		expError      error
+		// expErrStatus the status code of the OperationOutcomeError returned, 0 if no error is expected
+		expErrStatus int
 	}{
 		{
 			name:          "default sandbox client with nil opts",
@@ -43,6 +45,7 @@ func TestPatientGet(t *testing.T) {
 			},
 			id:            "9449304424",
 			expStatusCode: 401,
+			expErrStatus:  401,
 		},
 		{
 			name: "custom http client",
@@ -72,10 +75,17 @@ func TestPatientGet(t *testing.T) {
 
 			p, res, err := c.Patient.Get(ctx, tt.id)
 
-			assert.Equal(t, tt.expError, err)
+			if tt.expErrStatus != 0 {
+				var outcomeErr *client.OperationOutcomeError
+				if assert.True(t, errors.As(err, &outcomeErr), "expected an OperationOutcomeError got %v", err) {
+					assert.Equal(t, tt.expErrStatus, outcomeErr.StatusCode)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
 			assert.Equal(t, tt.expStatusCode, res.StatusCode)
 
-			if err != nil {
+			if err == nil {
 				if p == nil {
 					t.Errorf("NewClient returned nil for patient GET")
 				} else {
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Environment the NHS API environment the client calls.
+// https://digital.nhs.uk/developer/guides-and-documentation/testing#environments
+type Environment string
+
+const (
+	// Sandbox a stateless environment which returns test data and doesn't require auth
+	Sandbox Environment = "sandbox"
+	// Integration the environment used to test your application against realistic data before going live, it requires auth
+	Integration Environment = "integration"
+	// Production the live environment, it requires auth
+	Production Environment = "production"
+	// Custom an environment you run yourself, such as a local stand-in for the API.
+	// The urls are taken from Options.BaseURL and AuthConfigOptions.BaseURL
+	Custom Environment = "custom"
+)
+
+// ErrInvalidEnvironment error for when the environment isn't one of the supported environments
+var ErrInvalidEnvironment = errors.New("environment is invalid")
+
+// ErrAuthRequired error for when the environment requires auth but no token source or auth config was given
+var ErrAuthRequired = errors.New("environment requires auth but no auth is configured")
+
+// ErrCustomBaseURLMissing error for when the custom environment is used without a base url
+var ErrCustomBaseURLMissing = errors.New("custom environment requires a base url")
+
+// ErrEnvironmentURLConflict error for when a url is given which doesn't belong to the environment
+var ErrEnvironmentURLConflict = errors.New("url doesn't match the environment")
+
+// environmentURLs the urls of the API and auth for an environment
+type environmentURLs struct {
+	baseURL     string
+	authBaseURL string
+	// requiresAuth whether requests must be authenticated, tokens are never sent when this is false
+	requiresAuth bool
+}
+
+var environments = map[Environment]environmentURLs{
+	Sandbox: {
+		baseURL: sandboxURL,
+	},
+	Integration: {
+		baseURL:      "https://int.api.service.nhs.uk/",
+		authBaseURL:  "https://int.api.service.nhs.uk",
+		requiresAuth: true,
+	},
+	Production: {
+		baseURL:      "https://api.service.nhs.uk/",
+		authBaseURL:  "https://api.service.nhs.uk",
+		requiresAuth: true,
+	},
+}
+
+// applyEnvironment sets the urls of the environment on the options, the options are copied so the caller's aren't changed.
+// An error is returned if a url is given which belongs to another environment, or the custom environment has no base url.
+func applyEnvironment(opts Options) (*Options, error) {
+	if opts.Environment == Custom && opts.BaseURL == "" {
+		return nil, ErrCustomBaseURLMissing
+	}
+	if opts.Environment == "" || opts.Environment == Custom {
+		return &opts, nil
+	}
+
+	env, ok := environments[opts.Environment]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidEnvironment, opts.Environment)
+	}
+
+	if opts.BaseURL != "" && !isSameURL(opts.BaseURL, env.baseURL) {
+		return nil, fmt.Errorf("%w: base url %v isn't the %v url %v", ErrEnvironmentURLConflict, opts.BaseURL, opts.Environment, env.baseURL)
+	}
+	opts.BaseURL = env.baseURL
+
+	if opts.AuthConfigOptions != nil && env.authBaseURL != "" {
+		authConfig := *opts.AuthConfigOptions
+		if authConfig.BaseURL != "" && !isSameURL(authConfig.BaseURL, env.authBaseURL) {
+			return nil, fmt.Errorf("%w: auth base url %v isn't the %v url %v", ErrEnvironmentURLConflict, authConfig.BaseURL, opts.Environment, env.authBaseURL)
+		}
+		authConfig.BaseURL = env.authBaseURL
+		opts.AuthConfigOptions = &authConfig
+	}
+
+	if env.requiresAuth && opts.TokenSource == nil && opts.AuthConfigOptions == nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthRequired, opts.Environment)
+	}
+
+	return &opts, nil
+}
+
+// requiresAuth reports whether requests to the environment are sent with an access token.
+// When no environment is given the sandbox is found by its host so it doesn't matter how the url was written.
+func (e Environment) requiresAuth(baseURL *url.URL) bool {
+	switch e {
+	case "":
+		sandbox, _ := url.Parse(sandboxURL)
+		return !strings.EqualFold(baseURL.Host, sandbox.Host)
+	case Custom:
+		return true
+	default:
+		return environments[e].requiresAuth
+	}
+}
+
+// isSameURL reports whether the urls are the same ignoring a trailing slash
+func isSameURL(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClientWithOptions_environment(t *testing.T) {
+	authConfig := &AuthConfigOptions{ClientID: "123", Kid: "test", PrivateKeyPemFile: "file.pem"}
+
+	tests := []struct {
+		name             string
+		opts             *Options
+		wantBaseURL      string
+		wantAuthBaseURL  string
+		wantAuthDisabled bool
+		wantErr          error
+	}{
+		{
+			name:             "sandbox",
+			opts:             &Options{Environment: Sandbox},
+			wantBaseURL:      "https://sandbox.api.service.nhs.uk/",
+			wantAuthDisabled: true,
+		},
+		{
+			name:            "integration sets the api and auth urls",
+			opts:            &Options{Environment: Integration, AuthConfigOptions: authConfig},
+			wantBaseURL:     "https://int.api.service.nhs.uk/",
+			wantAuthBaseURL: "https://int.api.service.nhs.uk",
+		},
+		{
+			name:        "production with a token source",
+			opts:        &Options{Environment: Production, TokenSource: StaticTokenSource("token")},
+			wantBaseURL: "https://api.service.nhs.uk/",
+		},
+		{
+			name:        "base url of the environment without a trailing slash",
+			opts:        &Options{Environment: Production, BaseURL: "https://api.service.nhs.uk", TokenSource: StaticTokenSource("token")},
+			wantBaseURL: "https://api.service.nhs.uk/",
+		},
+		{
+			name:    "integration requires auth",
+			opts:    &Options{Environment: Integration},
+			wantErr: ErrAuthRequired,
+		},
+		{
+			name:    "base url of another environment",
+			opts:    &Options{Environment: Integration, BaseURL: "https://api.service.nhs.uk", TokenSource: StaticTokenSource("token")},
+			wantErr: ErrEnvironmentURLConflict,
+		},
+		{
+			name: "auth url of another environment",
+			opts: &Options{
+				Environment:       Production,
+				AuthConfigOptions: &AuthConfigOptions{BaseURL: "https://int.api.service.nhs.uk", ClientID: "123", Kid: "test", PrivateKeyPemFile: "file.pem"},
+			},
+			wantErr: ErrEnvironmentURLConflict,
+		},
+		{
+			name:    "unknown environment",
+			opts:    &Options{Environment: "staging"},
+			wantErr: ErrInvalidEnvironment,
+		},
+		{
+			name:        "custom uses the urls given",
+			opts:        &Options{Environment: Custom, BaseURL: "http://localhost:9000/", TokenSource: StaticTokenSource("token")},
+			wantBaseURL: "http://localhost:9000/",
+		},
+		{
+			name:    "custom without a base url",
+			opts:    &Options{Environment: Custom, TokenSource: StaticTokenSource("token")},
+			wantErr: ErrCustomBaseURLMissing,
+		},
+		{
+			name:             "custom with auth disabled",
+			opts:             &Options{Environment: Custom, BaseURL: "http://localhost:9000/", DisableAuth: true},
+			wantBaseURL:      "http://localhost:9000/",
+			wantAuthDisabled: true,
+		},
+		{
+			name:             "sandbox url without an environment doesn't send tokens",
+			opts:             &Options{BaseURL: "https://sandbox.api.service.nhs.uk", TokenSource: StaticTokenSource("token")},
+			wantBaseURL:      "https://sandbox.api.service.nhs.uk",
+			wantAuthDisabled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClientWithOptions(tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewClientWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantBaseURL, c.BaseURL.String())
+			assert.Equal(t, tt.wantAuthDisabled, c.authDisabled)
+			if tt.wantAuthBaseURL != "" {
+				assert.Equal(t, tt.wantAuthBaseURL, c.authConfig.BaseURL)
+			}
+		})
+	}
+
+	// the callers options aren't changed
+	assert.Empty(t, authConfig.BaseURL)
+}
+
+func TestNewRequest_authDisabled(t *testing.T) {
+	var got string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		w.Write([]byte(`{"resourceType":"Patient"}`))
+	}))
+	defer svr.Close()
+
+	for _, disabled := range []bool{false, true} {
+		c, err := NewClientWithOptions(&Options{
+			Client:      svr.Client(),
+			Environment: Custom,
+			BaseURL:     svr.URL + "/",
+			TokenSource: StaticTokenSource("token"),
+			DisableAuth: disabled,
+		})
+		if err != nil {
+			t.Fatalf("couldnt init client: %v", err)
+		}
+		if _, _, err := c.Patient.Get(context.Background(), "9000000009"); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if disabled {
+			assert.Empty(t, got)
+		} else {
+			assert.Equal(t, "Bearer token", got)
+		}
+	}
+}
@@ -1,11 +1,205 @@
 package client
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
 
 // RateLimitError contains information relating to this type of error
 type RateLimitError struct {
+	// RetryAfter the time the API asked us to wait before trying again, 0 if it wasn't given
+	RetryAfter time.Duration
+	// Attempts the number of times the request was sent
+	Attempts int
 }
 
 func (e *RateLimitError) Error() string {
-	return fmt.Sprintln("You have exceeeded the rate limit for this API.")
+	return fmt.Sprintf("You have exceeeded the rate limit for this API. attempts: %v, retry after: %v\n", e.Attempts, e.RetryAfter)
+}
+
+// VersionConflictError is returned when the version sent in the If-Match header
+// no longer matches the latest version of the patient held by the PDS.
+// Get the patient again to obtain the latest version and re-apply your changes.
+type VersionConflictError struct {
+	// Version the version that was sent to the PDS
+	Version string
+	// Response the response received from the PDS
+	Response *Response
+	// Outcome the error returned by the PDS
+	Outcome *OperationOutcomeError
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version %q of the patient is out of date, status code: %v", e.Version, e.Response.StatusCode)
+}
+
+// Unwrap returns the error returned by the PDS
+func (e *VersionConflictError) Unwrap() error {
+	if e.Outcome == nil {
+		return nil
+	}
+	return e.Outcome
+}
+
+// InvalidPatchError is returned when the PDS rejects the patch sent in an update with INVALID_UPDATE,
+// any other error in the request is returned as an OperationOutcomeError
+type InvalidPatchError struct {
+	// Response the response received from the PDS
+	Response *Response
+	// Outcome the error returned by the PDS
+	Outcome *OperationOutcomeError
+}
+
+func (e *InvalidPatchError) Error() string {
+	if e.Outcome != nil && e.Outcome.Diagnostics != "" {
+		return fmt.Sprintf("the patch was rejected by the PDS, status code: %v, diagnostics: %v", e.Response.StatusCode, e.Outcome.Diagnostics)
+	}
+	return fmt.Sprintf("the patch was rejected by the PDS, status code: %v", e.Response.StatusCode)
+}
+
+// Unwrap returns the error returned by the PDS
+func (e *InvalidPatchError) Unwrap() error {
+	if e.Outcome == nil {
+		return nil
+	}
+	return e.Outcome
+}
+
+// ErrAuthConfig error for when NHS auth rejects a token request because of the auth config,
+// trying again won't help until the config is fixed. Use errors.Is(err, ErrAuthConfig) to check for any of
+// ErrInvalidKid, ErrUnknownClientID, ErrInvalidAudience or ErrInvalidSignature.
+var ErrAuthConfig = errors.New("nhs auth rejected the auth config")
+
+// ErrInvalidKid error for when NHS auth doesn't have a public key matching the kid
+var ErrInvalidKid = errors.New("kid is missing or doesn't match a public key of the application")
+
+// ErrUnknownClientID error for when NHS auth doesn't recognise the client id
+var ErrUnknownClientID = errors.New("client id isn't the api key of an application")
+
+// ErrInvalidAudience error for when the aud claim of the signed JWT isn't the token endpoint, check the auth base url
+var ErrInvalidAudience = errors.New("aud claim doesn't match the token endpoint")
+
+// ErrInvalidSignature error for when the signed JWT doesn't match the public key of its kid
+var ErrInvalidSignature = errors.New("jwt signature doesn't match the public key of the kid")
+
+// ErrAccessTokenMissing error for when NHS auth responds successfully without an access token
+var ErrAccessTokenMissing = errors.New("token response has no access token")
+
+// TokenError is returned when NHS auth rejects a request for an access token.
+// https://digital.nhs.uk/developer/guides-and-documentation/security-and-authorisation/application-restricted-restful-apis-signed-jwt-authentication#error-handling
+type TokenError struct {
+	// StatusCode the status code of the response
+	StatusCode int
+	// Code the OAuth error code e.g. invalid_request
+	Code string `json:"error"`
+	// Description why the request was rejected
+	Description string `json:"error_description"`
+	// MessageID the id of the response, give this to NHS when raising an issue
+	MessageID string `json:"message_id"`
+}
+
+func (e *TokenError) Error() string {
+	msg := fmt.Sprintf("nhs auth rejected the token request, status code: %v, error: %v", e.StatusCode, e.Code)
+	if e.Description != "" {
+		msg += ", description: " + e.Description
+	}
+	if e.MessageID != "" {
+		msg += ", message id: " + e.MessageID
+	}
+	return msg
+}
+
+// Retryable reports whether requesting a token again may succeed, this is true when NHS auth is unavailable
+// or when the signed JWT was rejected because it had already been used.
+func (e *TokenError) Retryable() bool {
+	if e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError {
+		return true
+	}
+	cause := e.classify()
+	return cause == errJTIReused || cause == errAuthUnavailable
+}
+
+// ConfigError returns the part of the auth config which was rejected, or nil if the error wasn't caused by the config
+func (e *TokenError) ConfigError() error {
+	if e.Retryable() {
+		return nil
+	}
+	return e.classify()
+}
+
+// errJTIReused classifies a token error caused by a signed JWT which has already been used
+var errJTIReused = errors.New("jti has already been used")
+
+// errAuthUnavailable classifies a token error caused by NHS auth being unavailable
+var errAuthUnavailable = errors.New("nhs auth is unavailable")
+
+// tokenErrorCodes the OAuth error codes which identify the problem on their own
+var tokenErrorCodes = map[string]error{
+	"invalid_client":          ErrUnknownClientID,
+	"unauthorized_client":     ErrUnknownClientID,
+	"server_error":            errAuthUnavailable,
+	"temporarily_unavailable": errAuthUnavailable,
+}
+
+// tokenErrorDescriptions the descriptions NHS auth sends with invalid_request, which it uses for most problems with the signed JWT.
+// They're matched exactly, lower cased, as documented in its error handling guide.
+var tokenErrorDescriptions = map[string]error{
+	"missing 'kid' header in client_assertion jwt":                          ErrInvalidKid,
+	"invalid 'kid' header in client_assertion jwt - no matching public key": ErrInvalidKid,
+	"invalid 'kid' header in jwt - no matching public key":                  ErrInvalidKid,
+	"missing or non-matching 'iss'/'sub' claims in client_assertion jwt":    ErrUnknownClientID,
+	"missing or non-matching iss/sub claims in jwt":                         ErrUnknownClientID,
+	"missing or invalid 'aud' claim in client_assertion jwt":                ErrInvalidAudience,
+	"missing or invalid aud claim in jwt":                                   ErrInvalidAudience,
+	"non-unique 'jti' claim in client_assertion jwt":                        errJTIReused,
+	"non-unique jti claim in jwt":                                           errJTIReused,
+}
+
+// classify works out the cause of the error from its OAuth error code, then from the exact description sent by NHS auth.
+// Descriptions which aren't known fall back to looking for the claim they mention, so a reworded description is still classified,
+// the message id isn't used as it's unique to every response.
+func (e *TokenError) classify() error {
+	if err, ok := tokenErrorCodes[e.Code]; ok {
+		return err
+	}
+	desc := strings.ToLower(strings.TrimSpace(e.Description))
+	if err, ok := tokenErrorDescriptions[desc]; ok {
+		return err
+	}
+
+	switch {
+	case strings.Contains(desc, "jti"):
+		return errJTIReused
+	case strings.Contains(desc, "kid"):
+		return ErrInvalidKid
+	case strings.Contains(desc, "aud"):
+		return ErrInvalidAudience
+	case strings.Contains(desc, "signature"):
+		return ErrInvalidSignature
+	case strings.Contains(desc, "iss/sub") || strings.Contains(desc, "client_id") || strings.Contains(desc, "api key"):
+		return ErrUnknownClientID
+	}
+	return nil
+}
+
+// Is allows errors.Is to check for ErrAuthConfig and the part of the config which was rejected
+func (e *TokenError) Is(target error) bool {
+	configErr := e.ConfigError()
+	if configErr == nil {
+		return false
+	}
+	return target == ErrAuthConfig || target == configErr
+}
+
+// newTokenError reads the OAuth error from the body of an unsuccessful token response
+func newTokenError(resp *http.Response) *TokenError {
+	e := &TokenError{}
+	// the body isn't always json e.g. when a gateway in front of NHS auth fails, the status code is still set
+	_ = json.NewDecoder(resp.Body).Decode(e)
+	e.StatusCode = resp.StatusCode
+	return e
 }
@@ -0,0 +1,149 @@
+/*
+Package jwks builds the JSON Web Key Set (JWKS) containing the public keys NHS auth uses to check the JWTs signed by your application.
+
+The kid of each key must match the kid sent in the JWT header, see AuthConfigOptions.Kid.
+A KeyRing holds several keys so they can be rotated without downtime, the new key is published before it's used to sign
+and the old key is still published after it has been retired.
+
+https://digital.nhs.uk/developer/guides-and-documentation/security-and-authorisation/application-restricted-restful-apis-signed-jwt-authentication#step-3-generate-a-key-pair
+*/
+package jwks
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+)
+
+// ErrUnsupportedKey error for when the key isn't an RSA key
+var ErrUnsupportedKey = errors.New("key must be an RSA key")
+
+// ErrKidMissing error for when a key doesn't have a kid
+var ErrKidMissing = errors.New("kid is missing but required")
+
+// ErrUnsupportedAlg error for when the algorithm isn't one of the RSA algorithms the client can sign with
+var ErrUnsupportedAlg = errors.New("alg must be RS256, RS384 or RS512")
+
+// DefaultAlg the algorithm of the keys when none is given, this is the default signing method of the client
+const DefaultAlg = "RS512"
+
+// algs the algorithms a key can be published with
+var algs = map[string]bool{"RS256": true, "RS384": true, "RS512": true}
+
+// Key a JSON Web Key containing an RSA public key
+type Key struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// Set a JSON Web Key Set
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+// NewKey creates the JSON Web Key for the public part of an RSA key, it's published with the DefaultAlg.
+// key can be an *rsa.PrivateKey, *rsa.PublicKey or a crypto.Signer with an RSA public key e.g. a key held in a KMS.
+func NewKey(kid string, key interface{}) (Key, error) {
+	return NewKeyWithAlg(kid, DefaultAlg, key)
+}
+
+// NewKeyWithAlg creates the JSON Web Key for the public part of an RSA key used with the alg e.g. RS256,
+// this must be the alg of the signing method used with the key.
+func NewKeyWithAlg(kid, alg string, key interface{}) (Key, error) {
+	if kid == "" {
+		return Key{}, ErrKidMissing
+	}
+	if !algs[alg] {
+		return Key{}, fmt.Errorf("%w: got %q", ErrUnsupportedAlg, alg)
+	}
+	pub, err := publicKey(key)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: alg,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}, nil
+}
+
+// NewSet creates a JSON Web Key Set from the keys
+func NewSet(keys ...Key) Set {
+	return Set{Keys: append([]Key{}, keys...)}
+}
+
+// Marshal creates the JSON Web Key Set document from RSA keys, keyed by kid
+func Marshal(keys map[string]interface{}) ([]byte, error) {
+	set := Set{Keys: []Key{}}
+	for kid, key := range keys {
+		k, err := NewKey(kid, key)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, k)
+	}
+	// order by kid so the document is the same every time
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return json.Marshal(set)
+}
+
+// publicKey returns the RSA public key of key
+func publicKey(key interface{}) (*rsa.PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k != nil {
+			return k, nil
+		}
+	case *rsa.PrivateKey:
+		if k != nil {
+			return &k.PublicKey, nil
+		}
+	case crypto.Signer:
+		if pub, ok := k.Public().(*rsa.PublicKey); ok {
+			return pub, nil
+		}
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// SetSource provides the key set served by Handler, KeyRing implements this
+type SetSource interface {
+	Set() Set
+}
+
+// Set returns the key set, this allows a static Set to be served by Handler
+func (s Set) Set() Set {
+	return s
+}
+
+// Handler serves the key set as JSON, register it at the url given to NHS when you created your application
+func Handler(source SetSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := json.Marshal(source.Set())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(b)
+	})
+}
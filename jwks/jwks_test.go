@@ -0,0 +1,191 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldnt generate key: %v", err)
+	}
+	return key
+}
+
+func TestNewKey(t *testing.T) {
+	rsaKey := newKey(t)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	tests := []struct {
+		name    string
+		kid     string
+		alg     string
+		key     interface{}
+		wantErr error
+	}{
+		{name: "private key", kid: "test-1", key: rsaKey},
+		{name: "public key", kid: "test-1", key: &rsaKey.PublicKey},
+		{name: "signer", kid: "test-1", key: struct{ *rsa.PrivateKey }{rsaKey}},
+		{name: "not an rsa key", kid: "test-1", key: ecKey, wantErr: ErrUnsupportedKey},
+		{name: "kid missing", key: rsaKey, wantErr: ErrKidMissing},
+		{name: "alg", kid: "test-1", alg: "RS256", key: rsaKey},
+		{name: "alg isn't rsa", kid: "test-1", alg: "ES256", key: rsaKey, wantErr: ErrUnsupportedAlg},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantAlg := DefaultAlg
+			got, err := NewKey(tt.kid, tt.key)
+			if tt.alg != "" {
+				wantAlg = tt.alg
+				got, err = NewKeyWithAlg(tt.kid, tt.alg, tt.key)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			assert.Equal(t, "RSA", got.Kty)
+			assert.Equal(t, "sig", got.Use)
+			assert.Equal(t, wantAlg, got.Alg)
+			assert.Equal(t, tt.kid, got.Kid)
+
+			n, _ := base64.RawURLEncoding.DecodeString(got.N)
+			e, _ := base64.RawURLEncoding.DecodeString(got.E)
+			assert.Equal(t, rsaKey.N, new(big.Int).SetBytes(n))
+			assert.Equal(t, rsaKey.E, int(new(big.Int).SetBytes(e).Int64()))
+			assert.Equal(t, "AQAB", got.E)
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	b, err := Marshal(map[string]interface{}{"test-2": newKey(t), "test-1": newKey(t)})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	set := Set{}
+	if err := json.Unmarshal(b, &set); err != nil {
+		t.Fatalf("invalid jwks: %v", err)
+	}
+	if assert.Len(t, set.Keys, 2) {
+		assert.Equal(t, "test-1", set.Keys[0].Kid)
+		assert.Equal(t, "test-2", set.Keys[1].Kid)
+	}
+}
+
+func TestKeyRing_Active(t *testing.T) {
+	rotation := time.Date(2022, 1, 10, 9, 0, 0, 0, time.UTC)
+	ring, err := NewKeyRing(
+		RingKey{Kid: "old", PrivateKey: newKey(t), NotAfter: rotation.Add(time.Hour)},
+		RingKey{Kid: "new", PrivateKey: newKey(t), NotBefore: rotation},
+	)
+	if err != nil {
+		t.Fatalf("NewKeyRing() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		want    string
+		wantErr error
+	}{
+		{name: "before rotation", now: rotation.Add(-time.Minute), want: "old"},
+		{name: "at rotation the newest key is used", now: rotation, want: "new"},
+		{name: "after the old key has retired", now: rotation.Add(2 * time.Hour), want: "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ring.Active(tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Active() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got.Kid)
+		})
+	}
+
+	// retired and upcoming keys are published
+	set := ring.Set()
+	if assert.Len(t, set.Keys, 2) {
+		assert.Equal(t, "old", set.Keys[0].Kid)
+		assert.Equal(t, "new", set.Keys[1].Kid)
+	}
+
+	ring.Remove("new")
+	if _, err := ring.Active(rotation.Add(2 * time.Hour)); !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("expected %v got %v", ErrNoActiveKey, err)
+	}
+	assert.Len(t, ring.Set().Keys, 1)
+}
+
+func TestKeyRing_Add(t *testing.T) {
+	ring, _ := NewKeyRing(RingKey{Kid: "test-1", PrivateKey: newKey(t)})
+
+	if err := ring.Add(RingKey{Kid: "test-1", PrivateKey: newKey(t)}); !errors.Is(err, ErrDuplicateKid) {
+		t.Errorf("expected %v got %v", ErrDuplicateKid, err)
+	}
+	if err := ring.Add(RingKey{Kid: "test-2"}); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("expected %v got %v", ErrUnsupportedKey, err)
+	}
+	if err := ring.Add(RingKey{PrivateKey: newKey(t)}); !errors.Is(err, ErrKidMissing) {
+		t.Errorf("expected %v got %v", ErrKidMissing, err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err := ring.Add(RingKey{Kid: "test-3", PrivateKey: ecKey}); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("expected %v got %v", ErrUnsupportedKey, err)
+	}
+	if err := ring.Add(RingKey{Kid: "test-4", PrivateKey: newKey(t), Alg: "HS256"}); !errors.Is(err, ErrUnsupportedAlg) {
+		t.Errorf("expected %v got %v", ErrUnsupportedAlg, err)
+	}
+
+	// any signer with an rsa public key can be used, such as a kms key
+	if err := ring.Add(RingKey{Kid: "test-5", PrivateKey: struct{ *rsa.PrivateKey }{newKey(t)}, Alg: "RS256"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	set := ring.Set()
+	if assert.Len(t, set.Keys, 2) {
+		assert.Equal(t, DefaultAlg, set.Keys[0].Alg)
+		assert.Equal(t, "RS256", set.Keys[1].Alg)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ring, _ := NewKeyRing(RingKey{Kid: "test-1", PrivateKey: newKey(t)})
+	svr := httptest.NewServer(Handler(ring))
+	defer svr.Close()
+
+	resp, err := http.Get(svr.URL)
+	if err != nil {
+		t.Fatalf("couldnt get jwks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	set := Set{}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatalf("invalid jwks: %v", err)
+	}
+	assert.Equal(t, ring.Set(), set)
+
+	resp, err = http.Post(svr.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("couldnt post jwks: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
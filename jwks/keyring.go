@@ -0,0 +1,131 @@
+package jwks
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNoActiveKey error for when none of the keys in the ring can be used to sign at the time
+var ErrNoActiveKey = errors.New("key ring has no key valid at this time")
+
+// ErrDuplicateKid error for when a key with the same kid is already in the ring
+var ErrDuplicateKid = errors.New("key ring already has a key with this kid")
+
+// RingKey a key in a KeyRing and the window it's used to sign in
+type RingKey struct {
+	// Kid the key identifier sent in the JWT header and published in the key set
+	Kid string
+	// PrivateKey the key used to sign, any crypto.Signer with an RSA public key can be used e.g. an *rsa.PrivateKey or a key held in a KMS
+	PrivateKey crypto.Signer
+	// Alg the algorithm the key signs with and is published with, one of RS256, RS384 or RS512. Defaults to DefaultAlg
+	Alg string
+	// NotBefore the key isn't used to sign before this time, the zero value means it can be used straight away.
+	// Publish the key set long enough before this time for NHS auth to fetch it.
+	NotBefore time.Time
+	// NotAfter the key is retired and no longer used to sign after this time, the zero value means it doesn't retire.
+	// Retired keys are still published until they're removed from the ring.
+	NotAfter time.Time
+}
+
+// alg returns the algorithm of the key or the default
+func (k RingKey) alg() string {
+	if k.Alg == "" {
+		return DefaultAlg
+	}
+	return k.Alg
+}
+
+// validAt reports whether the key can be used to sign at the time
+func (k RingKey) validAt(now time.Time) bool {
+	return !now.Before(k.NotBefore) && (k.NotAfter.IsZero() || now.Before(k.NotAfter))
+}
+
+// KeyRing holds the keys of your application so they can be rotated without downtime.
+// The key used to sign is the one which became valid most recently, all the keys in the ring are published.
+// A KeyRing is safe for concurrent use.
+type KeyRing struct {
+	mu   sync.RWMutex
+	keys []RingKey
+}
+
+// NewKeyRing creates a key ring containing the keys
+func NewKeyRing(keys ...RingKey) (*KeyRing, error) {
+	r := &KeyRing{}
+	for _, k := range keys {
+		if err := r.Add(k); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Add adds a key to the ring
+func (r *KeyRing) Add(key RingKey) error {
+	if key.Kid == "" {
+		return ErrKidMissing
+	}
+	if key.PrivateKey == nil {
+		return fmt.Errorf("%w: private key of %q is nil", ErrUnsupportedKey, key.Kid)
+	}
+	// the public key is created now so a key which can't be published isn't added
+	if _, err := NewKeyWithAlg(key.Kid, key.alg(), key.PrivateKey); err != nil {
+		return err
+	}
+	key.Alg = key.alg()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range r.keys {
+		if k.Kid == key.Kid {
+			return fmt.Errorf("%w: %q", ErrDuplicateKid, key.Kid)
+		}
+	}
+	r.keys = append(r.keys, key)
+	return nil
+}
+
+// Remove removes the key from the ring so it's no longer published
+func (r *KeyRing) Remove(kid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, k := range r.keys {
+		if k.Kid == kid {
+			r.keys = append(r.keys[:i:i], r.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+// Active returns the key to sign with at the time, this is the valid key with the latest NotBefore
+func (r *KeyRing) Active(now time.Time) (RingKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var active *RingKey
+	for i, k := range r.keys {
+		if k.validAt(now) && (active == nil || k.NotBefore.After(active.NotBefore)) {
+			active = &r.keys[i]
+		}
+	}
+	if active == nil {
+		return RingKey{}, ErrNoActiveKey
+	}
+	return *active, nil
+}
+
+// Set returns the public keys of every key in the ring, including keys which aren't valid yet and retired keys
+func (r *KeyRing) Set() Set {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := Set{Keys: make([]Key, 0, len(r.keys))}
+	for _, k := range r.keys {
+		// keys are checked when they're added so this can't fail
+		key, _ := NewKeyWithAlg(k.Kid, k.Alg, k.PrivateKey)
+		set.Keys = append(set.Keys, key)
+	}
+	return set
+}
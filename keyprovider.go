@@ -0,0 +1,252 @@
+package client
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ErrInvalidKey error for when the private key isn't an RSA key
+var ErrInvalidKey = errors.New("private key must be an RSA key")
+
+// ErrInvalidKeyPEM error for when the private key isn't PEM encoded
+var ErrInvalidKeyPEM = errors.New("private key must be PEM encoded")
+
+// ErrLegacyEncryptedKey error for when the private key is encrypted with the insecure legacy PEM encryption (RFC 1423),
+// convert it to encrypted PKCS#8 with: openssl pkcs8 -topk8 -v2 aes-256-cbc -in key.pem -out key.p8.pem
+var ErrLegacyEncryptedKey = errors.New("private key uses legacy PEM encryption, convert it to encrypted PKCS#8")
+
+// ErrPassphraseMissing error for when the private key is encrypted but no passphrase was given
+var ErrPassphraseMissing = errors.New("private key is encrypted but the passphrase is missing")
+
+// KeyProvider provides the key used to sign the client assertion and its kid.
+// Key is called every time a new client assertion is signed so a provider can rotate its key.
+// Use this to sign with a key held in an HSM or cloud KMS, see NewSignerKeyProvider.
+type KeyProvider interface {
+	// Key returns the key to sign with, its public key must be an *rsa.PublicKey
+	Key() (signer crypto.Signer, kid string, err error)
+}
+
+// staticKeyProvider always provides the same key
+type staticKeyProvider struct {
+	signer crypto.Signer
+	kid    string
+}
+
+func (p staticKeyProvider) Key() (crypto.Signer, string, error) {
+	return p.signer, p.kid, nil
+}
+
+// NewSignerKeyProvider provides any crypto.Signer with an RSA public key, such as a key held in an HSM or cloud KMS
+func NewSignerKeyProvider(kid string, signer crypto.Signer) (KeyProvider, error) {
+	if kid == "" {
+		return nil, ErrKidMissing
+	}
+	if err := checkRSASigner(signer); err != nil {
+		return nil, err
+	}
+	return staticKeyProvider{signer: signer, kid: kid}, nil
+}
+
+// NewPEMKeyProvider provides a PEM encoded private key, see ParsePrivateKeyPEM for the supported formats.
+// passphrase is only needed when the key is encrypted.
+func NewPEMKeyProvider(kid string, pemBytes, passphrase []byte) (KeyProvider, error) {
+	if kid == "" {
+		return nil, ErrKidMissing
+	}
+	signer, err := ParsePrivateKeyPEM(pemBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return staticKeyProvider{signer: signer, kid: kid}, nil
+}
+
+// NewPKCS12KeyProvider provides the private key of a PKCS#12 (.p12 or .pfx) bundle
+func NewPKCS12KeyProvider(kid string, pfxData []byte, password string) (KeyProvider, error) {
+	if kid == "" {
+		return nil, ErrKidMissing
+	}
+	signer, err := parsePKCS12(pfxData, password)
+	if err != nil {
+		return nil, err
+	}
+	return staticKeyProvider{signer: signer, kid: kid}, nil
+}
+
+// FileKeyProvider provides the private key in a file and reloads it when the file changes on disk,
+// so a key renewed by a secret manager is picked up without restarting.
+// Files ending in .p12 or .pfx are read as PKCS#12 bundles, anything else as PEM.
+// A FileKeyProvider is safe for concurrent use.
+type FileKeyProvider struct {
+	kid        string
+	path       string
+	passphrase []byte
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	signer  crypto.Signer
+}
+
+// NewFileKeyProvider provides the private key in the file at path.
+// passphrase is only needed when the key is encrypted. The file is read straight away so a missing or invalid key is found early.
+func NewFileKeyProvider(kid, path string, passphrase []byte) (*FileKeyProvider, error) {
+	if kid == "" {
+		return nil, ErrKidMissing
+	}
+	p := &FileKeyProvider{kid: kid, path: path, passphrase: passphrase}
+	if _, _, err := p.Key(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Key returns the key in the file, reloading it if the file has changed since it was last read.
+// If the changed file can't be read the error is returned and it's read again on the next call.
+func (p *FileKeyProvider) Key() (crypto.Signer, string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.signer != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.signer, p.kid, nil
+	}
+
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, "", err
+	}
+	var signer crypto.Signer
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".p12", ".pfx":
+		signer, err = parsePKCS12(b, string(p.passphrase))
+	default:
+		signer, err = ParsePrivateKeyPEM(b, p.passphrase)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading private key %v: %w", p.path, err)
+	}
+
+	p.signer, p.modTime, p.size = signer, info.ModTime(), info.Size()
+	return p.signer, p.kid, nil
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded RSA private key. The supported formats are
+// PKCS#1 (RSA PRIVATE KEY), PKCS#8 (PRIVATE KEY) and encrypted PKCS#8 (ENCRYPTED PRIVATE KEY).
+// PKCS#1 encrypted with a legacy OpenSSL Proc-Type header isn't supported as the encryption is insecure, see ErrLegacyEncryptedKey.
+// passphrase is only needed when the key is encrypted.
+func ParsePrivateKeyPEM(pemBytes, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, ErrInvalidKeyPEM
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseMissing
+		}
+		key, err = pkcs8.ParsePKCS8PrivateKey(block.Bytes, passphrase)
+	case "RSA PRIVATE KEY":
+		if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+			return nil, ErrLegacyEncryptedKey
+		}
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: unsupported PEM block type %q", ErrInvalidKeyPEM, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key: %w", err)
+	}
+	return toRSASigner(key)
+}
+
+// parsePKCS12 returns the private key of a PKCS#12 bundle
+func parsePKCS12(pfxData []byte, password string) (crypto.Signer, error) {
+	key, _, _, err := pkcs12.DecodeChain(pfxData, password)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing PKCS#12 bundle: %w", err)
+	}
+	return toRSASigner(key)
+}
+
+// toRSASigner checks the parsed key is an RSA private key
+func toRSASigner(key interface{}) (crypto.Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	if err := checkRSASigner(signer); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// checkRSASigner checks the public key of the signer is an RSA key
+func checkRSASigner(signer crypto.Signer) error {
+	if signer == nil {
+		return ErrInvalidKey
+	}
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// signerMethod signs JWTs with a crypto.Signer using RSASSA-PKCS1-v1_5, so keys which can't be exported such as
+// HSM and KMS keys can be used. Verification is done by the RSA signing method of the jwt library.
+type signerMethod struct {
+	*jwt.SigningMethodRSA
+}
+
+// newSignerMethod wraps the RSA signing method, defaulting to RS512
+func newSignerMethod(method jwt.SigningMethod) (*signerMethod, error) {
+	if isNil(method) {
+		return &signerMethod{jwt.SigningMethodRS512}, nil
+	}
+	// look the method up by alg so wrapped RSA methods are supported
+	rsaMethod, ok := jwt.GetSigningMethod(method.Alg()).(*jwt.SigningMethodRSA)
+	if !ok {
+		return nil, ErrInvalidSigningMethodAlg
+	}
+	return &signerMethod{rsaMethod}, nil
+}
+
+// Sign signs the signing string with key which must be a crypto.Signer
+func (m *signerMethod) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	if !m.Hash.Available() {
+		return "", jwt.ErrHashUnavailable
+	}
+
+	hasher := m.Hash.New()
+	hasher.Write([]byte(signingString))
+	sig, err := signer.Sign(rand.Reader, hasher.Sum(nil), m.Hash)
+	if err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(sig), nil
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// kmsSigner a signer which doesn't expose its private key like a key held in a KMS
+type kmsSigner struct {
+	key *rsa.PrivateKey
+}
+
+func (s kmsSigner) Public() crypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+func (s kmsSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func newPKCS12(t *testing.T, key *rsa.PrivateKey, password string) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("couldnt create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pfx, err := pkcs12.Modern.Encode(key, cert, nil, password)
+	if err != nil {
+		t.Fatalf("couldnt encode pkcs12: %v", err)
+	}
+	return pfx
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	key, pkcs1 := newPrivateKeyPEM(t)
+	passphrase := []byte("secret")
+
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	pkcs8PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	encrypted, err := pkcs8.MarshalPrivateKey(key, passphrase, nil)
+	if err != nil {
+		t.Fatalf("couldnt encrypt key: %v", err)
+	}
+	encryptedPEM := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted})
+
+	// only the headers matter as legacy encrypted keys are refused before they're decrypted
+	legacyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:    "RSA PRIVATE KEY",
+		Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": "AES-256-CBC,00112233445566778899AABBCCDDEEFF"},
+		Bytes:   []byte("encrypted"),
+	})
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	ecPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecDER})
+
+	tests := []struct {
+		name       string
+		pem        []byte
+		passphrase []byte
+		wantErr    error
+	}{
+		{name: "pkcs1", pem: pkcs1},
+		{name: "pkcs8", pem: pkcs8PEM},
+		{name: "encrypted pkcs8", pem: encryptedPEM, passphrase: passphrase},
+		{name: "legacy encrypted pkcs1", pem: legacyPEM, passphrase: passphrase, wantErr: ErrLegacyEncryptedKey},
+		{name: "encrypted pkcs8 without passphrase", pem: encryptedPEM, wantErr: ErrPassphraseMissing},
+		{name: "not pem", pem: []byte("not a key"), wantErr: ErrInvalidKeyPEM},
+		{name: "not an rsa key", pem: ecPEM, wantErr: ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrivateKeyPEM(tt.pem, tt.passphrase)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParsePrivateKeyPEM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			assert.Equal(t, &key.PublicKey, got.Public())
+		})
+	}
+
+	if _, err := ParsePrivateKeyPEM(encryptedPEM, []byte("wrong")); err == nil {
+		t.Error("expected an error for the wrong passphrase")
+	}
+}
+
+func TestNewPKCS12KeyProvider(t *testing.T) {
+	key, _ := newPrivateKeyPEM(t)
+	pfx := newPKCS12(t, key, "secret")
+
+	p, err := NewPKCS12KeyProvider("test-1", pfx, "secret")
+	if err != nil {
+		t.Fatalf("NewPKCS12KeyProvider() error = %v", err)
+	}
+	signer, kid, _ := p.Key()
+	assert.Equal(t, "test-1", kid)
+	assert.Equal(t, &key.PublicKey, signer.Public())
+
+	if _, err := NewPKCS12KeyProvider("test-1", pfx, "wrong"); err == nil {
+		t.Error("expected an error for the wrong password")
+	}
+}
+
+func TestFileKeyProvider_reload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key.pem")
+	oldKey, oldPEM := newPrivateKeyPEM(t)
+	newKey, newPEM := newPrivateKeyPEM(t)
+
+	if err := os.WriteFile(path, oldPEM, 0600); err != nil {
+		t.Fatalf("couldnt write key: %v", err)
+	}
+	p, err := NewFileKeyProvider("test-1", path, nil)
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider() error = %v", err)
+	}
+	signer, _, _ := p.Key()
+	assert.Equal(t, &oldKey.PublicKey, signer.Public())
+
+	// a renewed key is picked up without recreating the provider
+	if err := os.WriteFile(path, newPEM, 0600); err != nil {
+		t.Fatalf("couldnt write key: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	signer, kid, err := p.Key()
+	if err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+	assert.Equal(t, "test-1", kid)
+	assert.Equal(t, &newKey.PublicKey, signer.Public())
+
+	// a half written key is an error until the file is fixed
+	if err := os.WriteFile(path, newPEM[:20], 0600); err != nil {
+		t.Fatalf("couldnt write key: %v", err)
+	}
+	if _, _, err := p.Key(); !errors.Is(err, ErrInvalidKeyPEM) {
+		t.Errorf("expected %v got %v", ErrInvalidKeyPEM, err)
+	}
+
+	pfxPath := filepath.Join(dir, "key.p12")
+	os.WriteFile(pfxPath, newPKCS12(t, newKey, "secret"), 0600)
+	p, err = NewFileKeyProvider("test-1", pfxPath, []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider() error = %v", err)
+	}
+	signer, _, _ = p.Key()
+	assert.Equal(t, &newKey.PublicKey, signer.Public())
+
+	if _, err := NewFileKeyProvider("test-1", filepath.Join(dir, "missing.pem"), nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v got %v", os.ErrNotExist, err)
+	}
+}
+
+func TestGenerateSecret_keyProvider(t *testing.T) {
+	key, _ := newPrivateKeyPEM(t)
+	provider, err := NewSignerKeyProvider("kms-1", kmsSigner{key: key})
+	if err != nil {
+		t.Fatalf("NewSignerKeyProvider() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+	}{
+		{name: "default signing method", method: nil},
+		{name: "RS256", method: jwt.SigningMethodRS256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := AuthConfigOptions{
+				BaseURL:       "https://int.api.service.nhs.uk",
+				ClientID:      "123",
+				KeyProvider:   provider,
+				SigningMethod: tt.method,
+			}
+			secret, err := generateSecret(config, time.Now())
+			if err != nil {
+				t.Fatalf("generateSecret() error = %v", err)
+			}
+			token, err := jwt.Parse(*secret, func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			})
+			if err != nil {
+				t.Fatalf("jwt isn't signed by the provided key: %v", err)
+			}
+			want := "RS512"
+			if tt.method != nil {
+				want = tt.method.Alg()
+			}
+			assert.Equal(t, want, token.Header["alg"])
+			assert.Equal(t, "kms-1", token.Header["kid"])
+		})
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := NewSignerKeyProvider("kms-1", ecKey); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected %v got %v", ErrInvalidKey, err)
+	}
+}
+
+func TestGenerateSecret_privateKey(t *testing.T) {
+	key, _ := newPrivateKeyPEM(t)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	pkcs8PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	encrypted, err := pkcs8.MarshalPrivateKey(key, []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("couldnt encrypt key: %v", err)
+	}
+	encryptedPEM := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted})
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr error
+	}{
+		{name: "pkcs8", key: pkcs8PEM},
+		{name: "encrypted keys need a key provider", key: encryptedPEM, wantErr: ErrPassphraseMissing},
+		{name: "not pem", key: []byte("not a key"), wantErr: ErrInvalidKeyPEM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := AuthConfigOptions{BaseURL: "https://int.api.service.nhs.uk", ClientID: "123", Kid: "test", PrivateKey: tt.key}
+			secret, err := generateSecret(config, time.Now())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("generateSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if _, err := jwt.Parse(*secret, func(token *jwt.Token) (interface{}, error) {
+				return &key.PublicKey, nil
+			}); err != nil {
+				t.Errorf("jwt isn't signed by the key: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Level the level of a log event, the values match log/slog
+type Level int
+
+const (
+	// LevelDebug events which are only useful when debugging e.g. a request being sent
+	LevelDebug Level = -4
+	// LevelInfo events which record normal operation e.g. a response being received
+	LevelInfo Level = 0
+	// LevelWarn events which may need attention e.g. a request being retried
+	LevelWarn Level = 4
+	// LevelError events which need attention e.g. a request failing
+	LevelError Level = 8
+)
+
+// Field a key value pair attached to a log event
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the structured events logged by the client, adapt your logger with NewSlogLogger,
+// the zaplog package or the logruslog package.
+type Logger interface {
+	// Log logs the event, msg is the name of the event e.g. request.end
+	Log(ctx context.Context, level Level, msg string, fields ...Field)
+}
+
+// LogEvent the name of an event logged by the client
+type LogEvent string
+
+// The events logged by the client. Events never contain patient data, NHS numbers are removed from paths and errors,
+// the urls in errors lose their query and query strings and bodies aren't logged.
+const (
+	// EventRequestStart a request is about to be sent. Fields: method, path, attempt, request_id
+	EventRequestStart LogEvent = "request.start"
+	// EventRequestEnd a response was received. Fields: method, path, attempt, request_id, status, latency
+	EventRequestEnd LogEvent = "request.end"
+	// EventRequestError a request couldn't be sent. Fields: method, path, attempt, request_id, latency, error
+	EventRequestError LogEvent = "request.error"
+	// EventRetry a request is going to be sent again. Fields: method, path, attempt, request_id, status, wait
+	EventRetry LogEvent = "request.retry"
+	// EventRateLimitWait the client waited for its rate limiter. Fields: wait
+	EventRateLimitWait LogEvent = "ratelimit.wait"
+	// EventTokenRefresh a new access token was obtained. Fields: latency, expiry
+	EventTokenRefresh LogEvent = "token.refresh"
+	// EventTokenError a new access token couldn't be obtained. Fields: latency, error
+	EventTokenError LogEvent = "token.error"
+)
+
+// defaultEventLevels the level each event is logged at unless LoggingOptions.Levels overrides it
+var defaultEventLevels = map[LogEvent]Level{
+	EventRequestStart:  LevelDebug,
+	EventRequestEnd:    LevelInfo,
+	EventRequestError:  LevelError,
+	EventRetry:         LevelWarn,
+	EventRateLimitWait: LevelDebug,
+	EventTokenRefresh:  LevelInfo,
+	EventTokenError:    LevelError,
+}
+
+// LoggingOptions the options used to log what the client is doing
+type LoggingOptions struct {
+	// Logger receives the events
+	Logger Logger
+	// Level the minimum level of the events logged. Defaults to LevelInfo
+	Level Level
+	// Levels overrides the level an event is logged at e.g. {client.EventRequestEnd: client.LevelDebug}
+	Levels map[LogEvent]Level
+}
+
+// eventLogger logs the events of a client, a nil eventLogger logs nothing
+type eventLogger struct {
+	logger   Logger
+	level    Level
+	levels   map[LogEvent]Level
+	redactor *redactor
+}
+
+// newEventLogger creates the event logger for the options, nil is returned if there's no logger
+func newEventLogger(opts *LoggingOptions) *eventLogger {
+	if opts == nil || opts.Logger == nil {
+		return nil
+	}
+	l := &eventLogger{logger: opts.Logger, level: opts.Level, levels: map[LogEvent]Level{}, redactor: newRedactor(nil)}
+	for event, level := range defaultEventLevels {
+		l.levels[event] = level
+	}
+	for event, level := range opts.Levels {
+		l.levels[event] = level
+	}
+	return l
+}
+
+// log logs the event if its level is enabled
+func (l *eventLogger) log(ctx context.Context, event LogEvent, fields ...Field) {
+	if l == nil {
+		return
+	}
+	level := l.levels[event]
+	if level < l.level {
+		return
+	}
+	for i, f := range fields {
+		if err, ok := f.Value.(error); ok {
+			fields[i].Value = l.redactor.text(replaceURLs(err.Error(), templateURL), redactedString)
+		}
+	}
+	l.logger.Log(ctx, level, string(event), fields...)
+}
+
+// pathTemplate returns the path of the url with NHS numbers replaced by {nhsNumber}.
+// The query is dropped, even when it has been escaped into the path.
+func pathTemplate(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	path, _, _ := strings.Cut(u.Path, "?")
+	return nhsNumberPattern.ReplaceAllString(path, "{nhsNumber}")
+}
+
+// templateURL returns the url without its query and with its path replaced by the path template
+func templateURL(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String() + pathTemplate(u)
+}
+
+// requestFields the fields describing a request
+func requestFields(method string, u *url.URL, requestID string, attempt int) []Field {
+	return []Field{
+		{Key: "method", Value: method},
+		{Key: "path", Value: pathTemplate(u)},
+		{Key: "request_id", Value: requestID},
+		{Key: "attempt", Value: attempt},
+	}
+}
+
+// loggingTokenSource logs and measures every new token obtained by the source, it goes under the cache so only refreshes are logged
+type loggingTokenSource struct {
+	source  TokenSource
+	log     func(ctx context.Context, event LogEvent, fields ...Field)
+	metrics *metricsRecorder
+	now     func() time.Time
+}
+
+func (s *loggingTokenSource) Token(ctx context.Context) (*Token, error) {
+	start := s.now()
+	token, err := s.source.Token(ctx)
+	latency := s.now().Sub(start)
+	s.metrics.tokenRefresh(latency, err)
+	if err != nil {
+		s.log(ctx, EventTokenError, Field{Key: "latency", Value: latency}, Field{Key: "error", Value: err})
+		return nil, err
+	}
+	s.log(ctx, EventTokenRefresh, Field{Key: "latency", Value: latency}, Field{Key: "expiry", Value: token.Expiry})
+	return token, nil
+}
+
+// slogLogger adapts a slog.Logger into a Logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger which logs to a log/slog logger
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) Log(ctx context.Context, level Level, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	l.logger.LogAttrs(ctx, slog.Level(level), msg, attrs...)
+}
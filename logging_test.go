@@ -0,0 +1,243 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logEntry an event received by recordingLogger
+type logEntry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordingLogger keeps every event
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) Log(ctx context.Context, level Level, msg string, fields ...Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := logEntry{level: level, msg: msg, fields: map[string]interface{}{}}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	l.entries = append(l.entries, e)
+}
+
+func (l *recordingLogger) events() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := []string{}
+	for _, e := range l.entries {
+		events = append(events, e.msg)
+	}
+	return events
+}
+
+func TestClient_logging(t *testing.T) {
+	var requests int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/token" {
+			w.Write([]byte(`{"access_token":"token","expires_in":"599","token_type":"Bearer"}`))
+			return
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"resourceType":"Bundle","entry":[{"resource":{"id":"9000000009"}}]}`))
+	}))
+	defer svr.Close()
+
+	logger := &recordingLogger{}
+	c, err := NewClientWithOptions(&Options{
+		BaseURL:     svr.URL,
+		Environment: Custom,
+		TokenSource: StaticTokenSource("token"),
+		RetryPolicy: &RetryPolicy{BaseBackoff: time.Millisecond},
+		RateLimit:   &RateLimit{TPS: 20, Burst: 1},
+		LoggingOptions: &LoggingOptions{
+			Logger: logger,
+			Level:  LevelDebug,
+		},
+	})
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+
+	if _, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{Family: createString("Smith")}); err != nil {
+		t.Fatalf("Patient.Search() error = %v", err)
+	}
+
+	events := logger.events()
+	assert.Equal(t, []string{"request.start", "request.end", "request.retry"}, events[:3])
+	assert.Contains(t, events, "ratelimit.wait")
+	assert.Equal(t, "request.end", events[len(events)-1])
+
+	for _, e := range logger.entries {
+		b, _ := json.Marshal(e.fields)
+		assert.NotContains(t, string(b), "9000000009", "event %v contains an nhs number", e.msg)
+		assert.NotContains(t, string(b), "Smith", "event %v contains a name", e.msg)
+	}
+
+	end := logger.entries[len(logger.entries)-1]
+	assert.Equal(t, LevelInfo, end.level)
+	assert.Equal(t, http.MethodGet, end.fields["method"])
+	assert.Equal(t, "/personal-demographics/FHIR/R4/Patient", end.fields["path"])
+	assert.Equal(t, http.StatusOK, end.fields["status"])
+	assert.Equal(t, 2, end.fields["attempt"])
+	assert.NotEmpty(t, end.fields["request_id"])
+	assert.IsType(t, time.Duration(0), end.fields["latency"])
+}
+
+func TestClient_logging_requestError(t *testing.T) {
+	// nothing is listening so the request can't be sent
+	svr := httptest.NewServer(http.NotFoundHandler())
+	svr.Close()
+
+	logger := &recordingLogger{}
+	c, err := NewClientWithOptions(&Options{
+		BaseURL:        svr.URL + "/",
+		Environment:    Custom,
+		DisableAuth:    true,
+		RetryPolicy:    &RetryPolicy{MaxAttempts: 1},
+		LoggingOptions: &LoggingOptions{Logger: logger},
+	})
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+
+	if _, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{Family: createString("Smith")}); err == nil {
+		t.Fatal("Patient.Search() expected an error")
+	}
+	if _, _, err := c.Patient.Get(context.Background(), "9000000009"); err == nil {
+		t.Fatal("Patient.Get() expected an error")
+	}
+
+	if !assert.Equal(t, []string{"request.error", "request.error"}, logger.events()) {
+		return
+	}
+	// the url in the error of the search loses its query
+	assert.Contains(t, logger.entries[0].fields["error"], `"`+svr.URL+`/personal-demographics/FHIR/R4/Patient"`)
+	assert.Contains(t, logger.entries[1].fields["error"], `"`+svr.URL+`/personal-demographics/FHIR/R4/Patient/{nhsNumber}"`)
+	for _, e := range logger.entries {
+		assert.NotContains(t, e.fields["error"], "Smith")
+		assert.NotContains(t, e.fields["error"], "9000000009")
+	}
+}
+
+func TestClient_postForm_logging(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"token","expires_in":"599","token_type":"Bearer"}`))
+	}))
+	defer svr.Close()
+
+	logger := &recordingLogger{}
+	c, err := NewClientWithOptions(&Options{
+		Client:         svr.Client(),
+		LoggingOptions: &LoggingOptions{Logger: logger, Level: LevelDebug},
+	})
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+
+	if _, err := c.postForm(context.Background(), svr.URL+"/oauth2/token", map[string][]string{"grant_type": {"client_credentials"}}, &AccessTokenResponse{}); err != nil {
+		t.Fatalf("Client.postForm() error = %v", err)
+	}
+
+	assert.Equal(t, []string{"request.start", "request.end"}, logger.events())
+	end := logger.entries[1]
+	assert.Equal(t, http.MethodPost, end.fields["method"])
+	assert.Equal(t, "/oauth2/token", end.fields["path"])
+	assert.Equal(t, http.StatusOK, end.fields["status"])
+	assert.Equal(t, 1, end.fields["attempt"])
+}
+
+func TestClient_logging_tokenRefresh(t *testing.T) {
+	var tokenRequests int32
+	svr := newAuthServer(t, 599, &tokenRequests)
+	defer svr.Close()
+	_, keyPEM := newPrivateKeyPEM(t)
+
+	logger := &recordingLogger{}
+	c, err := NewClientWithOptions(&Options{
+		Client:            svr.Client(),
+		AuthConfigOptions: &AuthConfigOptions{BaseURL: svr.URL, ClientID: "123", Kid: "test", PrivateKey: keyPEM},
+		LoggingOptions:    &LoggingOptions{Logger: logger},
+	})
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.getAccessToken(context.Background()); err != nil {
+			t.Fatalf("couldnt get access token: %v", err)
+		}
+	}
+	// cached tokens aren't refreshes
+	assert.Equal(t, []string{"request.end", "token.refresh"}, logger.events())
+}
+
+func TestEventLogger_levels(t *testing.T) {
+	tests := []struct {
+		name string
+		opts LoggingOptions
+		want []string
+	}{
+		{name: "debug events are skipped by default", want: []string{"request.end", "request.retry"}},
+		{name: "minimum level", opts: LoggingOptions{Level: LevelWarn}, want: []string{"request.retry"}},
+		{
+			name: "overridden level",
+			opts: LoggingOptions{Level: LevelWarn, Levels: map[LogEvent]Level{EventRequestEnd: LevelWarn, EventRetry: LevelDebug}},
+			want: []string{"request.end"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			tt.opts.Logger = logger
+			l := newEventLogger(&tt.opts)
+			for _, event := range []LogEvent{EventRequestStart, EventRequestEnd, EventRetry} {
+				l.log(context.Background(), event)
+			}
+			assert.Equal(t, tt.want, logger.events())
+		})
+	}
+
+	// a client without a logger doesn't log
+	var l *eventLogger
+	l.log(context.Background(), EventRequestEnd)
+}
+
+func TestNewSlogLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	l := newEventLogger(&LoggingOptions{Logger: logger, Level: LevelDebug})
+
+	l.log(context.Background(), EventRequestError, Field{Key: "path", Value: "/Patient/{nhsNumber}"},
+		Field{Key: "error", Value: &TokenError{StatusCode: 500, Description: "patient 9000000009"}})
+
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("log isn't json: %v", err)
+	}
+	assert.Equal(t, "ERROR", got["level"])
+	assert.Equal(t, "request.error", got["msg"])
+	assert.Equal(t, "/Patient/{nhsNumber}", got["path"])
+	assert.True(t, strings.Contains(got["error"].(string), redactedString), "error isn't redacted: %v", got["error"])
+}
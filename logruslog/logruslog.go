@@ -0,0 +1,40 @@
+// Package logruslog adapts a logrus logger into the Logger of the nhs-fhir client
+package logruslog
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	client "github.com/welldigital/nhs-fhir"
+)
+
+// logger adapts a logrus.Logger or logrus.Entry into a client.Logger
+type logger struct {
+	logger logrus.FieldLogger
+}
+
+// New returns a client.Logger which logs to the logrus logger, this can be a *logrus.Logger or a *logrus.Entry
+func New(l logrus.FieldLogger) client.Logger {
+	return &logger{logger: l}
+}
+
+func (l *logger) Log(ctx context.Context, level client.Level, msg string, fields ...client.Field) {
+	logrusFields := make(logrus.Fields, len(fields))
+	for _, f := range fields {
+		logrusFields[f.Key] = f.Value
+	}
+	l.logger.WithFields(logrusFields).WithContext(ctx).Log(Level(level), msg)
+}
+
+// Level converts the level of an event to the logrus level
+func Level(level client.Level) logrus.Level {
+	switch {
+	case level >= client.LevelError:
+		return logrus.ErrorLevel
+	case level >= client.LevelWarn:
+		return logrus.WarnLevel
+	case level >= client.LevelInfo:
+		return logrus.InfoLevel
+	}
+	return logrus.DebugLevel
+}
@@ -0,0 +1,43 @@
+package logruslog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	client "github.com/welldigital/nhs-fhir"
+)
+
+func TestLogger(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	l.SetLevel(logrus.DebugLevel)
+	logger := New(l)
+
+	logger.Log(context.Background(), client.LevelWarn, "request.retry",
+		client.Field{Key: "path", Value: "/Patient/{nhsNumber}"}, client.Field{Key: "attempt", Value: 1})
+
+	entries := hook.AllEntries()
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	assert.Equal(t, logrus.WarnLevel, entries[0].Level)
+	assert.Equal(t, "request.retry", entries[0].Message)
+	assert.Equal(t, logrus.Fields{"path": "/Patient/{nhsNumber}", "attempt": 1}, entries[0].Data)
+}
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		level client.Level
+		want  logrus.Level
+	}{
+		{client.LevelDebug, logrus.DebugLevel},
+		{client.LevelInfo, logrus.InfoLevel},
+		{client.LevelWarn, logrus.WarnLevel},
+		{client.LevelError, logrus.ErrorLevel},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Level(tt.level))
+	}
+}
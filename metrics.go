@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/welldigital/nhs-fhir/model"
+)
+
+// Metrics receives the measurements made by the client, use the prommetrics package to record them with Prometheus
+// or implement it to send them somewhere else. Like log events, measurements never contain patient data.
+type Metrics interface {
+	// ObserveRequest records a request sent to the API or NHS auth.
+	// operation is the name of the call e.g. pds.patient.get, statusClass is the class of the status e.g. 2xx,
+	// or error when no response was received
+	ObserveRequest(operation, statusClass string, latency time.Duration)
+	// IncRetry records a request being sent again
+	IncRetry(operation string)
+	// IncRateLimited records the API responding with 429 Too Many Requests
+	IncRateLimited(operation string)
+	// ObserveRateLimitWait records the time spent waiting on the client's rate limiter
+	ObserveRateLimitWait(wait time.Duration)
+	// ObserveTokenRefresh records a new access token being requested, failed is true if it couldn't be obtained
+	ObserveTokenRefresh(latency time.Duration, failed bool)
+	// SetTokenAge records the age of the access token sent with a request, it grows until the token is refreshed
+	SetTokenAge(age time.Duration)
+	// ObserveSearchScore records how closely a patient found by a search matched, 1 is an exact match
+	ObserveSearchScore(score float64)
+}
+
+// StatusClassError the status class of a request which didn't get a response
+const StatusClassError = "error"
+
+// unknownOperation the operation of a request made outside of a service method
+const unknownOperation = "unknown"
+
+// operationKey the context key of the name of the operation a request is made for
+type operationKey struct{}
+
+// withOperation returns a context for the requests made by the operation
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// operationFromContext returns the name of the operation the request is made for
+func operationFromContext(ctx context.Context) string {
+	if operation, ok := ctx.Value(operationKey{}).(string); ok {
+		return operation
+	}
+	return unknownOperation
+}
+
+// statusClass returns the class of the response status e.g. 4xx
+func statusClass(resp *http.Response) string {
+	if resp == nil {
+		return StatusClassError
+	}
+	return fmt.Sprintf("%dxx", resp.StatusCode/100)
+}
+
+// metricsRecorder records the measurements of a client, a nil metricsRecorder records nothing
+type metricsRecorder struct {
+	metrics Metrics
+}
+
+// newMetricsRecorder creates the recorder for the metrics, nil is returned if there are no metrics
+func newMetricsRecorder(metrics Metrics) *metricsRecorder {
+	if metrics == nil {
+		return nil
+	}
+	return &metricsRecorder{metrics: metrics}
+}
+
+// request records the response to a request, or the lack of one
+func (m *metricsRecorder) request(ctx context.Context, resp *http.Response, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	operation := operationFromContext(ctx)
+	m.metrics.ObserveRequest(operation, statusClass(resp), latency)
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		m.metrics.IncRateLimited(operation)
+	}
+}
+
+func (m *metricsRecorder) retry(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	m.metrics.IncRetry(operationFromContext(ctx))
+}
+
+func (m *metricsRecorder) rateLimitWait(wait time.Duration) {
+	if m == nil {
+		return
+	}
+	m.metrics.ObserveRateLimitWait(wait)
+}
+
+func (m *metricsRecorder) tokenRefresh(latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.metrics.ObserveTokenRefresh(latency, err != nil)
+}
+
+// tokenAge records the age of the token, tokens without an issue time are ignored
+func (m *metricsRecorder) tokenAge(token *Token, now time.Time) {
+	if m == nil || token.IssuedAt.IsZero() {
+		return
+	}
+	m.metrics.SetTokenAge(now.Sub(token.IssuedAt))
+}
+
+// searchScores records the score of every patient found
+func (m *metricsRecorder) searchScores(result *model.Result) {
+	if m == nil {
+		return
+	}
+	for _, entry := range result.Entry {
+		m.metrics.ObserveSearchScore(entry.Search.Score)
+	}
+}
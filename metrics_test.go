@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingMetrics keeps every measurement
+type recordingMetrics struct {
+	mu             sync.Mutex
+	requests       []string
+	retries        []string
+	rateLimited    []string
+	rateLimitWaits []time.Duration
+	tokenRefreshes []bool
+	tokenAges      []time.Duration
+	searchScores   []float64
+}
+
+func (m *recordingMetrics) ObserveRequest(operation, statusClass string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, operation+" "+statusClass)
+}
+
+func (m *recordingMetrics) IncRetry(operation string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries = append(m.retries, operation)
+}
+
+func (m *recordingMetrics) IncRateLimited(operation string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rateLimited = append(m.rateLimited, operation)
+}
+
+func (m *recordingMetrics) ObserveRateLimitWait(wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rateLimitWaits = append(m.rateLimitWaits, wait)
+}
+
+func (m *recordingMetrics) ObserveTokenRefresh(latency time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokenRefreshes = append(m.tokenRefreshes, failed)
+}
+
+func (m *recordingMetrics) SetTokenAge(age time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokenAges = append(m.tokenAges, age)
+}
+
+func (m *recordingMetrics) ObserveSearchScore(score float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.searchScores = append(m.searchScores, score)
+}
+
+func newMetricsClient(t *testing.T, svr *recordingServer, metrics Metrics) *Client {
+	return newTestClient(t, svr.Server, &Options{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		RateLimit:   &RateLimit{TPS: 50, Burst: 1},
+		Metrics:     metrics,
+	})
+}
+
+func TestClient_metrics(t *testing.T) {
+	t.Run("get", func(t *testing.T) {
+		svr := newRecordingServer(`{"resourceType":"Patient","id":"9000000009"}`, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK)
+		defer svr.Close()
+		metrics := &recordingMetrics{}
+		c := newMetricsClient(t, svr, metrics)
+
+		if _, _, err := c.Patient.Get(context.Background(), "9000000009"); err != nil {
+			t.Fatalf("Patient.Get() error = %v", err)
+		}
+
+		assert.Equal(t, []string{"nhs.oauth.token 2xx", "pds.patient.get 4xx", "pds.patient.get 5xx", "pds.patient.get 2xx"}, metrics.requests)
+		assert.Equal(t, []string{"pds.patient.get", "pds.patient.get"}, metrics.retries)
+		assert.Equal(t, []string{"pds.patient.get"}, metrics.rateLimited)
+		assert.NotEmpty(t, metrics.rateLimitWaits, "the requests sent straight after each other should wait for the limiter")
+		assert.Equal(t, []bool{false}, metrics.tokenRefreshes)
+		if assert.Len(t, metrics.tokenAges, 1) {
+			assert.True(t, metrics.tokenAges[0] >= 0 && metrics.tokenAges[0] < time.Minute, "unexpected token age %v", metrics.tokenAges[0])
+		}
+		assert.Empty(t, metrics.searchScores)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		svr := newRecordingServer(`{"resourceType":"Bundle","entry":[{"search":{"score":1}},{"search":{"score":0.75}}]}`, http.StatusOK)
+		defer svr.Close()
+		metrics := &recordingMetrics{}
+		c := newMetricsClient(t, svr, metrics)
+
+		if _, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{Family: createString("Smith")}); err != nil {
+			t.Fatalf("Patient.Search() error = %v", err)
+		}
+
+		assert.Equal(t, []string{"nhs.oauth.token 2xx", "pds.patient.search 2xx"}, metrics.requests)
+		assert.Equal(t, []float64{1, 0.75}, metrics.searchScores)
+	})
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		resp *http.Response
+		want string
+	}{
+		{nil, StatusClassError},
+		{&http.Response{StatusCode: http.StatusOK}, "2xx"},
+		{&http.Response{StatusCode: http.StatusTooManyRequests}, "4xx"},
+		{&http.Response{StatusCode: http.StatusBadGateway}, "5xx"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, statusClass(tt.resp))
+	}
+}
@@ -0,0 +1,299 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ErrReadOnlyField error for when a diff tries to change a field the PDS doesn't allow to be updated
+var ErrReadOnlyField = errors.New("field is read only")
+
+// ErrPatientMissing error for when a patient passed into Diff is nil
+var ErrPatientMissing = errors.New("patient is missing but required")
+
+// ErrElementIDMissing error for when Diff needs to change or remove an array element which doesn't have an id.
+// The element can't be tested without its id, testing its whole value would fail on any field the model doesn't know about.
+var ErrElementIDMissing = errors.New("array element has no id so it can't be safely changed or removed")
+
+// readOnlyFields top level fields of the patient which can not be changed with a patch
+var readOnlyFields = map[string]bool{
+	"resourceType": true,
+	"id":           true,
+	"identifier":   true,
+	"meta":         true,
+}
+
+// arrayKeys the field used to match elements of the patient's top level arrays.
+// Extensions don't have an id so they are matched using their url.
+var arrayKeys = map[string]string{
+	"name":                "id",
+	"address":             "id",
+	"telecom":             "id",
+	"contact":             "id",
+	"generalPractitioner": "id",
+	"extension":           "url",
+}
+
+// Diff compares two versions of a patient and returns the JSON Patch operations needed to turn before into after.
+// The result can be passed straight into PatientService.Update using the version found in before.Meta.VersionID.
+//
+// Elements of the Name, Address, Telecom, Contact and GeneralPractitioner arrays are matched by their ID
+// and Extension elements are matched by their URL, so changing the order of an array doesn't produce any operations.
+// A test operation is added before any element is changed or removed as required by the PDS, including when an array is emptied.
+// Elements without an id are left alone while they're unchanged, ErrElementIDMissing is returned if one is changed or removed.
+// An error is returned if the ID, Identifier or Meta of the patient differ as these can't be updated.
+func Diff(before, after *Patient) ([]PatchOp, error) {
+	if before == nil || after == nil {
+		return nil, ErrPatientMissing
+	}
+
+	if before.ResourceType != after.ResourceType {
+		return nil, fmt.Errorf("%w: resourceType", ErrReadOnlyField)
+	}
+	if before.ID != after.ID {
+		return nil, fmt.Errorf("%w: id", ErrReadOnlyField)
+	}
+	if !reflect.DeepEqual(before.Identifier, after.Identifier) {
+		return nil, fmt.Errorf("%w: identifier", ErrReadOnlyField)
+	}
+	if !reflect.DeepEqual(before.Meta, after.Meta) {
+		return nil, fmt.Errorf("%w: meta", ErrReadOnlyField)
+	}
+
+	b, err := toJSONObject(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toJSONObject(after)
+	if err != nil {
+		return nil, err
+	}
+
+	for field := range readOnlyFields {
+		delete(b, field)
+		delete(a, field)
+	}
+
+	d := &differ{}
+	d.object("", b, a)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.ops, nil
+}
+
+// differ accumulates the operations found when comparing two json documents, err is set if the documents can't be patched safely
+type differ struct {
+	ops []PatchOp
+	err error
+}
+
+func (d *differ) add(op, path string, value interface{}) {
+	d.ops = append(d.ops, PatchOp{Op: op, Path: path, Value: compact(value)})
+}
+
+// object compares two json objects key by key
+func (d *differ) object(path string, before, after map[string]interface{}) {
+	for _, key := range sortedKeys(before, after) {
+		d.value(path+"/"+escapePointer(key), before[key], after[key])
+	}
+}
+
+// value compares two json values found at the given path
+func (d *differ) value(path string, before, after interface{}) {
+	beforeArr, beforeIsArr := before.([]interface{})
+	afterArr, afterIsArr := after.([]interface{})
+	key, keyed := arrayKeys[strings.TrimPrefix(path, "/")]
+
+	switch {
+	case reflect.DeepEqual(before, after):
+		return
+	case isEmpty(before) && isEmpty(after):
+		return
+	case isEmpty(before):
+		d.add(PatchOpAdd, path, after)
+		return
+	case keyed && beforeIsArr && isEmpty(after):
+		// every element is removed one by one so each of them is tested first
+		d.array(path, key, beforeArr, nil)
+		return
+	case isEmpty(after):
+		d.add(PatchOpRemove, path, nil)
+		return
+	}
+
+	beforeObj, beforeIsObj := before.(map[string]interface{})
+	afterObj, afterIsObj := after.(map[string]interface{})
+	if beforeIsObj && afterIsObj {
+		d.object(path, beforeObj, afterObj)
+		return
+	}
+
+	if keyed && beforeIsArr && afterIsArr {
+		d.array(path, key, beforeArr, afterArr)
+		return
+	}
+
+	d.add(PatchOpReplace, path, after)
+}
+
+// array compares two arrays whose elements are matched using the key field, elements without a key only match an identical element.
+// Removed elements are removed in reverse order so that the positions of the remaining elements are known,
+// changed elements are patched in place and new elements are appended to the end of the array.
+func (d *differ) array(path, key string, before, after []interface{}) {
+	matched := make([]int, len(before))
+	used := make([]bool, len(after))
+	for i, b := range before {
+		matched[i] = -1
+		id := elementKey(b, key)
+		for j, a := range after {
+			if used[j] {
+				continue
+			}
+			if (id != "" && elementKey(a, key) == id) || (id == "" && reflect.DeepEqual(b, a)) {
+				matched[i] = j
+				used[j] = true
+				break
+			}
+		}
+	}
+
+	for i := len(before) - 1; i >= 0; i-- {
+		if matched[i] != -1 {
+			continue
+		}
+		elementPath := fmt.Sprintf("%s/%d", path, i)
+		id := elementKey(before[i], key)
+		if id == "" {
+			if d.err == nil {
+				d.err = fmt.Errorf("%w: %v", ErrElementIDMissing, elementPath)
+			}
+			continue
+		}
+		d.add(PatchOpTest, elementPath+"/"+key, id)
+		d.add(PatchOpRemove, elementPath, nil)
+	}
+
+	pos := 0
+	for i, b := range before {
+		if matched[i] == -1 {
+			continue
+		}
+		a := after[matched[i]]
+		if !reflect.DeepEqual(b, a) {
+			elementPath := fmt.Sprintf("%s/%d", path, pos)
+			d.add(PatchOpTest, elementPath+"/"+key, elementKey(b, key))
+			d.value(elementPath, b, a)
+		}
+		pos++
+	}
+
+	for j, a := range after {
+		if !used[j] {
+			d.add(PatchOpAdd, path+"/-", a)
+		}
+	}
+}
+
+// toJSONObject converts the patient into its generic json representation
+func toJSONObject(p *Patient) (map[string]interface{}, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]interface{}{}
+	err = json.Unmarshal(b, &obj)
+	return obj, err
+}
+
+// elementKey returns the value of the key field of an array element
+func elementKey(element interface{}, key string) string {
+	obj, ok := element.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	s, _ := obj[key].(string)
+	return s
+}
+
+// isEmpty reports whether the json value is treated as missing.
+// The patient model doesn't omit empty values so empty strings, zeros and empty arrays are treated as not set.
+func isEmpty(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case float64:
+		return t == 0
+	case []interface{}:
+		return len(t) == 0
+	case map[string]interface{}:
+		for _, value := range t {
+			if !isEmpty(value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// CompactValue converts the value into its json representation without its empty fields.
+// The patient model doesn't omit empty values, use this so that unset fields such as "id": "" aren't sent to the PDS.
+func CompactValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return nil, err
+	}
+	return compact(value), nil
+}
+
+// compact removes the empty fields from objects so that unset fields aren't sent to the PDS
+func compact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		obj := map[string]interface{}{}
+		for key, value := range t {
+			if !isEmpty(value) {
+				obj[key] = compact(value)
+			}
+		}
+		return obj
+	case []interface{}:
+		arr := make([]interface{}, len(t))
+		for i, value := range t {
+			arr[i] = compact(value)
+		}
+		return arr
+	}
+	return v
+}
+
+func sortedKeys(a, b map[string]interface{}) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, m := range []map[string]interface{}{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes a key for use in a JSON Pointer (RFC 6901)
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,193 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	base := func() *Patient {
+		return &Patient{
+			ResourceType: "Patient",
+			ID:           "9000000009",
+			Identifier:   []IdentifierElement{{System: "https://fhir.nhs.uk/Id/nhs-number", Value: "9000000009"}},
+			Meta:         Meta{VersionID: "2"},
+			Name:         []Name{{ID: "123", Use: "usual", Given: []string{"Jane"}, Family: "Smith"}},
+			Gender:       "female",
+			Address: []Address{
+				{ID: "456", Use: "home", Line: []string{"1 Trevelyan Square"}, PostalCode: "LS1 6AE"},
+				{ID: "457", Use: "temp", Line: []string{"2 Trevelyan Square"}, PostalCode: "LS1 6AE"},
+			},
+			Telecom: []ResourceTelecom{
+				{ID: "789", System: "phone", Value: "01632960587", Use: "home"},
+			},
+			Extension: []ResourceExtension{
+				{
+					URL: NominatedPharmacyURL,
+					ValueReference: &ValueReference{
+						Identifier: IdentifierElement{System: ODSOrganizationCodeSystem, Value: "Y12345"},
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name string
+		// changeBefore changes the patient before the change, when nil it is the base patient
+		changeBefore func(p *Patient)
+		change       func(p *Patient)
+		want         string
+		wantErr      error
+	}{
+		{
+			name:   "no changes",
+			change: func(p *Patient) {},
+			want:   `null`,
+		},
+		{
+			name: "replaces a scalar field",
+			change: func(p *Patient) {
+				p.Gender = "male"
+			},
+			want: `[{"op":"replace","path":"/gender","value":"male"}]`,
+		},
+		{
+			name: "adds a missing field",
+			change: func(p *Patient) {
+				p.BirthDate = "2010-10-22"
+			},
+			want: `[{"op":"add","path":"/birthDate","value":"2010-10-22"}]`,
+		},
+		{
+			name: "changes a field of an element matched by id",
+			change: func(p *Patient) {
+				p.Address[0], p.Address[1] = p.Address[1], p.Address[0]
+				p.Address[1].Period.End = "2021-12-31"
+			},
+			want: `[
+				{"op":"test","path":"/address/0/id","value":"456"},
+				{"op":"add","path":"/address/0/period","value":{"end":"2021-12-31"}}
+			]`,
+		},
+		{
+			name: "removes and adds elements",
+			change: func(p *Patient) {
+				p.Address = p.Address[1:]
+				p.Address[0].Line = []string{"3 Trevelyan Square"}
+				p.Telecom = append(p.Telecom, ResourceTelecom{System: "email", Value: "jane.smith@example.com"})
+			},
+			want: `[
+				{"op":"test","path":"/address/0/id","value":"456"},
+				{"op":"remove","path":"/address/0"},
+				{"op":"test","path":"/address/0/id","value":"457"},
+				{"op":"replace","path":"/address/0/line","value":["3 Trevelyan Square"]},
+				{"op":"add","path":"/telecom/-","value":{"system":"email","value":"jane.smith@example.com"}}
+			]`,
+		},
+		{
+			name: "tests every element of an emptied array before removing it",
+			change: func(p *Patient) {
+				p.Address = nil
+			},
+			want: `[
+				{"op":"test","path":"/address/1/id","value":"457"},
+				{"op":"remove","path":"/address/1"},
+				{"op":"test","path":"/address/0/id","value":"456"},
+				{"op":"remove","path":"/address/0"}
+			]`,
+		},
+		{
+			name: "leaves an unchanged element without an id alone",
+			changeBefore: func(p *Patient) {
+				p.Telecom = append([]ResourceTelecom{{System: "email", Value: "jane.smith@example.com"}}, p.Telecom...)
+			},
+			change: func(p *Patient) {
+				p.Telecom = append([]ResourceTelecom{{System: "email", Value: "jane.smith@example.com"}}, p.Telecom...)
+				p.Telecom[1].Use = "mobile"
+			},
+			want: `[
+				{"op":"test","path":"/telecom/1/id","value":"789"},
+				{"op":"replace","path":"/telecom/1/use","value":"mobile"}
+			]`,
+		},
+		{
+			name: "element without an id can't be removed",
+			changeBefore: func(p *Patient) {
+				p.Telecom = append(p.Telecom, ResourceTelecom{System: "email", Value: "jane.smith@example.com"})
+			},
+			change:  func(p *Patient) {},
+			wantErr: ErrElementIDMissing,
+		},
+		{
+			name: "element without an id can't be changed",
+			changeBefore: func(p *Patient) {
+				p.Telecom = append(p.Telecom, ResourceTelecom{System: "email", Value: "jane.smith@example.com"})
+			},
+			change: func(p *Patient) {
+				p.Telecom = append(p.Telecom, ResourceTelecom{System: "email", Value: "jane@example.com"})
+			},
+			wantErr: ErrElementIDMissing,
+		},
+		{
+			name: "matches extensions by url",
+			change: func(p *Patient) {
+				p.Extension[0].ValueReference.Identifier.Value = "Y23456"
+			},
+			want: `[
+				{"op":"test","path":"/extension/0/url","value":"https://fhir.hl7.org.uk/StructureDefinition/Extension-UKCore-NominatedPharmacy"},
+				{"op":"replace","path":"/extension/0/valueReference/identifier/value","value":"Y23456"}
+			]`,
+		},
+		{
+			name: "id is read only",
+			change: func(p *Patient) {
+				p.ID = "9000000017"
+			},
+			wantErr: ErrReadOnlyField,
+		},
+		{
+			name: "identifier is read only",
+			change: func(p *Patient) {
+				p.Identifier[0].Value = "9000000017"
+			},
+			wantErr: ErrReadOnlyField,
+		},
+		{
+			name: "meta is read only",
+			change: func(p *Patient) {
+				p.Meta.VersionID = "3"
+			},
+			wantErr: ErrReadOnlyField,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := base(), base()
+			if tt.changeBefore != nil {
+				tt.changeBefore(before)
+			}
+			tt.change(after)
+
+			got, err := Diff(before, after)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Diff() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			b, err := json.Marshal(got)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(b))
+		})
+	}
+}
+
+func TestDiff_nilPatient(t *testing.T) {
+	_, err := Diff(nil, &Patient{})
+	assert.ErrorIs(t, err, ErrPatientMissing)
+}
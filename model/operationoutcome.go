@@ -0,0 +1,38 @@
+package model
+
+// OperationOutcome the resource returned by the FHIR API when a request fails
+type OperationOutcome struct {
+	ResourceType string                  `json:"resourceType"`
+	Issue        []OperationOutcomeIssue `json:"issue"`
+}
+
+// OperationOutcomeIssue a single error or warning found when processing a request
+type OperationOutcomeIssue struct {
+	// Severity the severity of the issue e.g. error
+	Severity string `json:"severity"`
+	// Code the FHIR issue type e.g. value, not-found, processing
+	Code string `json:"code"`
+	// Details contains the NHS specific error code e.g. INVALID_RESOURCE_ID
+	Details CodeableConcept `json:"details"`
+	// Diagnostics additional information about the issue
+	Diagnostics string `json:"diagnostics,omitempty"`
+}
+
+// CodeableConcept a set of codes which describe a concept, such as the NHS specific error code of an issue
+type CodeableConcept struct {
+	Coding []Coding `json:"coding"`
+	// Text a human readable description of the concept
+	Text string `json:"text,omitempty"`
+}
+
+// Coding a code from a code system
+type Coding struct {
+	// System URI of the code system e.g. https://fhir.nhs.uk/R4/CodeSystem/Spine-ErrorOrWarningCode
+	System string `json:"system"`
+	// Version the version of the code system
+	Version string `json:"version,omitempty"`
+	// Code the code e.g. INVALID_RESOURCE_ID
+	Code string `json:"code"`
+	// Display a human readable description of the code
+	Display string `json:"display,omitempty"`
+}
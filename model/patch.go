@@ -0,0 +1,22 @@
+package model
+
+// List of JSON Patch operations supported by the PDS
+const (
+	PatchOpAdd     = "add"
+	PatchOpReplace = "replace"
+	PatchOpRemove  = "remove"
+	PatchOpTest    = "test"
+)
+
+// PatchOp a single JSON Patch (RFC 6902) operation applied to a patient resource.
+// Path is a JSON Pointer into the patient e.g. /name/0/given/0
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Patch the body sent to the PDS when partially updating a patient
+type Patch struct {
+	Patches []PatchOp `json:"patches"`
+}
@@ -8,3 +8,11 @@ type ResourceExtension struct {
 	ValueReference *ValueReference   `json:"valueReference,omitempty"`
 	ValueAddress   *ValueAddress     `json:"valueAddress,omitempty"`
 }
+
+// URLs and systems used by the patient extensions
+const (
+	// NominatedPharmacyURL the extension url of the patient's nominated pharmacy
+	NominatedPharmacyURL = "https://fhir.hl7.org.uk/StructureDefinition/Extension-UKCore-NominatedPharmacy"
+	// ODSOrganizationCodeSystem the identifier system of an Organisation Data Service (ODS) code
+	ODSOrganizationCodeSystem = "https://fhir.nhs.uk/Id/ods-organization-code"
+)
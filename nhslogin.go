@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/go-querystring/query"
+)
+
+// ErrIDTokenMissing error for when patient access is used without the NHS login ID token
+var ErrIDTokenMissing = errors.New("nhs login id token is missing but required for patient access")
+
+// ErrNHSNumberClaimMissing error for when the NHS login ID token doesn't contain the patient's NHS number
+var ErrNHSNumberClaimMissing = errors.New("nhs login id token doesn't contain an nhs_number claim")
+
+// ErrIdentityProofingLevel error for when the patient hasn't proved their identity to the level required by the PDS
+var ErrIdentityProofingLevel = errors.New("nhs login identity proofing level must be P9")
+
+const identityProofingLevelP9 = "P9"
+
+// PatientAccessOptions the options used for patient access.
+// The access token is provided with Options.TokenSource, or when AuthConfigOptions is set the ID token is
+// exchanged for an access token using the NHS login token exchange.
+type PatientAccessOptions struct {
+	// IDToken the ID token issued by NHS login when the patient logged in
+	IDToken string
+}
+
+// NHSLoginClaims the claims of an NHS login ID token
+type NHSLoginClaims struct {
+	// NHSNumber the NHS number of the patient
+	NHSNumber string `json:"nhs_number"`
+	// IdentityProofingLevel how well the patient has proved their identity e.g. P9
+	IdentityProofingLevel string `json:"identity_proofing_level"`
+	jwt.StandardClaims
+}
+
+// ParseNHSLoginIDToken reads the claims of an NHS login ID token and checks the patient has proved their identity to level P9.
+// The signature isn't verified, this is done by NHS auth when the token is exchanged. The claims are only used to
+// stop requests which the PDS would reject.
+func ParseNHSLoginIDToken(idToken string) (*NHSLoginClaims, error) {
+	claims := &NHSLoginClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(idToken, claims); err != nil {
+		return nil, fmt.Errorf("error parsing nhs login id token: %w", err)
+	}
+	if claims.NHSNumber == "" {
+		return nil, ErrNHSNumberClaimMissing
+	}
+	if claims.IdentityProofingLevel != identityProofingLevelP9 {
+		return nil, ErrIdentityProofingLevel
+	}
+	return claims, nil
+}
+
+// tokenExchangeRequest the values required to exchange an NHS login ID token for an access token
+type tokenExchangeRequest struct {
+	GrantType           string `url:"grant_type"`
+	SubjectTokenType    string `url:"subject_token_type"`
+	SubjectToken        string `url:"subject_token"`
+	ClientAssertionType string `url:"client_assertion_type"`
+	JWT                 string `url:"client_assertion"`
+}
+
+// NewNHSLoginTokenSource returns a TokenSource which exchanges the patient's NHS login ID token for an access token
+// authenticating with a signed JWT. Tokens are cached and refreshed when they expire within the refresh window.
+// If a nil httpClient is provided then a new http.Client will be used.
+// https://digital.nhs.uk/developer/guides-and-documentation/security-and-authorisation/user-restricted-restful-apis-nhs-login-separate-authentication-and-authorisation
+func NewNHSLoginTokenSource(config AuthConfigOptions, idToken string, httpClient *http.Client) (TokenSource, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := ParseNHSLoginIDToken(idToken); err != nil {
+		return nil, err
+	}
+	c, err := NewClientWithOptions(&Options{Client: httpClient})
+	if err != nil {
+		return nil, err
+	}
+	return newNHSLoginTokenSource(config, idToken, c), nil
+}
+
+// newNHSLoginTokenSource creates a cached token exchange source which sends its requests through the client
+func newNHSLoginTokenSource(config AuthConfigOptions, idToken string, c *Client) *reuseTokenSource {
+	return &reuseTokenSource{
+		source: &loggingTokenSource{
+			source:  &nhsLoginTokenSource{config: config, idToken: idToken, client: c, now: c.clock},
+			log:     c.log,
+			metrics: c.metrics,
+			now:     c.clock,
+		},
+		window: config.refreshWindow(),
+		now:    c.clock,
+	}
+}
+
+// nhsLoginTokenSource exchanges the NHS login ID token for a new access token every time it's called
+type nhsLoginTokenSource struct {
+	config  AuthConfigOptions
+	idToken string
+	client  IClient
+	now     func() time.Time
+}
+
+// Token exchanges the ID token for an access token
+func (s *nhsLoginTokenSource) Token(ctx context.Context) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	jwt, err := generateSecret(s.config, s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := query.Values(tokenExchangeRequest{
+		GrantType:           "urn:ietf:params:oauth:grant-type:token-exchange",
+		SubjectTokenType:    "urn:ietf:params:oauth:token-type:id_token",
+		SubjectToken:        s.idToken,
+		ClientAssertionType: "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+		JWT:                 *jwt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRes := &AccessTokenResponse{}
+	if _, err := s.client.postForm(ctx, s.config.BaseURL+"/oauth2/token", data, tokenRes); err != nil {
+		return nil, fmt.Errorf("error exchanging nhs login id token: %w", err)
+	}
+	if tokenRes.AccessToken == "" {
+		return nil, fmt.Errorf("error exchanging nhs login id token: %w", ErrAccessTokenMissing)
+	}
+
+	return newToken(tokenRes, s.now()), nil
+}
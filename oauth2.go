@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+)
+
+// oauth2TokenSource adapts an oauth2.TokenSource into a TokenSource
+type oauth2TokenSource struct {
+	source oauth2.TokenSource
+}
+
+// FromOAuth2 returns a TokenSource which gets its tokens from an oauth2.TokenSource.
+// oauth2.TokenSource doesn't take a context so the context passed to Token is ignored,
+// set any deadline on the context the oauth2 source was created with e.g. the one given to oauth2.Config.TokenSource.
+func FromOAuth2(source oauth2.TokenSource) TokenSource {
+	return &oauth2TokenSource{source: source}
+}
+
+func (s *oauth2TokenSource) Token(ctx context.Context) (*Token, error) {
+	t, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	return &Token{
+		AccessToken: t.AccessToken,
+		TokenType:   t.Type(),
+		Expiry:      t.Expiry,
+	}, nil
+}
+
+// tokenSourceAdapter adapts a TokenSource into an oauth2.TokenSource
+type tokenSourceAdapter struct {
+	ctx    context.Context
+	source TokenSource
+}
+
+// ToOAuth2 returns an oauth2.TokenSource which gets its tokens from a TokenSource.
+// This allows the signed JWT flow to be used with other HTTP clients via oauth2.NewClient.
+// The context is used for every token request.
+func ToOAuth2(ctx context.Context, source TokenSource) oauth2.TokenSource {
+	return &tokenSourceAdapter{ctx: ctx, source: source}
+}
+
+func (s *tokenSourceAdapter) Token() (*oauth2.Token, error) {
+	t, err := s.source.Token(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: t.AccessToken,
+		TokenType:   t.TokenType,
+		Expiry:      t.Expiry,
+	}, nil
+}
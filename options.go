@@ -13,10 +13,36 @@ import (
 type Options struct {
 	*http.Client
 	*AuthConfigOptions
-	BaseURL   string
-	UserAgent string
+	// TokenSource provides the access tokens used to authenticate requests, this takes precedence over AuthConfigOptions
+	TokenSource TokenSource
+	// Environment sets the urls of the API and auth, Options.BaseURL and AuthConfigOptions.BaseURL can be left empty.
+	// Use Custom to give the urls yourself
+	Environment Environment
+	// DisableAuth set to true to send requests without an access token e.g. to a local stand-in for the API
+	DisableAuth bool
+	BaseURL     string
+	UserAgent   string
 	*TracingOptions
+	*PollingOptions
+	*RetryPolicy
+	// RateLimit limits the rate of requests sent by this client to stay within your applications quota
+	*RateLimit
+	// Limiter limits the rate of requests, use this instead of RateLimit to share a quota between clients
+	Limiter Limiter
+	// AccessMode the access mode used to call the API. Defaults to ApplicationRestricted
+	AccessMode AccessMode
+	// HealthcareWorkerOptions is required for HealthcareWorker access
+	*HealthcareWorkerOptions
+	// PatientAccessOptions is required for PatientAccess
+	*PatientAccessOptions
+	// LoggingOptions logs structured events such as requests, retries and token refreshes
+	*LoggingOptions
+	// TelemetryOptions creates OpenTelemetry spans for API and token calls
+	*TelemetryOptions
+	// Metrics records request counts, latencies, retries, rate limiting and token refreshes e.g. prommetrics.New(registry)
+	Metrics Metrics
 }
+
 // TracingOptions the options used for debugging http requests/responses
 type TracingOptions struct {
 	// Enabled set to true to enable ALL tracing
@@ -25,6 +51,21 @@ type TracingOptions struct {
 	TraceErrorsOnly bool
 	// Output allows you to configure where the log output is outputted to. Defaults to os.Stdout
 	Output io.Writer
+	// Tracer receives every traced request and response. Defaults to NewTextTracer writing to Output
+	Tracer Tracer
+	// Redaction controls how patient data is removed from traces. Defaults to masking NHS numbers,
+	// DefaultRedactedFields and DefaultRedactedQueryParams
+	Redaction *RedactionPolicy
+}
+
+// PollingOptions the options used when the API responds with 202 Accepted and the result has to be polled for
+type PollingOptions struct {
+	// Manual set to true to return ErrAccepted and a Poller on the response instead of waiting for the result
+	Manual bool
+	// MaxWait the maximum time to wait for the result. Defaults to 30 seconds
+	MaxWait time.Duration
+	// Interval the time to wait between polls when the API doesn't send a Retry-After header. Defaults to 1 second
+	Interval time.Duration
 }
 
 func newDefaultBaseURL() *url.URL {
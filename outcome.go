@@ -0,0 +1,160 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/welldigital/nhs-fhir/model"
+)
+
+// Errors matching the error codes returned by the PDS, use errors.Is to check an OperationOutcomeError for one of these.
+// https://digital.nhs.uk/developer/api-catalogue/personal-demographics-service-fhir#api-description__error-handling
+var (
+	// ErrInvalidResourceID the NHS number is not valid or doesn't match the resource
+	ErrInvalidResourceID = errors.New("INVALID_RESOURCE_ID")
+	// ErrResourceNotFound the patient does not exist
+	ErrResourceNotFound = errors.New("RESOURCE_NOT_FOUND")
+	// ErrPreconditionFailed the If-Match version doesn't match the latest version of the patient
+	ErrPreconditionFailed = errors.New("PRECONDITION_FAILED")
+	// ErrInvalidSearchData the search parameters are not valid
+	ErrInvalidSearchData = errors.New("INVALID_SEARCH_DATA")
+	// ErrInvalidValue a value in the request is not valid
+	ErrInvalidValue = errors.New("INVALID_VALUE")
+	// ErrMissingValue a required value is missing from the request
+	ErrMissingValue = errors.New("MISSING_VALUE")
+	// ErrInvalidUpdate the patch can't be applied to the patient
+	ErrInvalidUpdate = errors.New("INVALID_UPDATE")
+	// ErrAccessDenied the access token doesn't allow the request
+	ErrAccessDenied = errors.New("ACCESS_DENIED")
+	// ErrTooManyMatches the search matched more patients than the maximum number of results
+	ErrTooManyMatches = errors.New("TOO_MANY_MATCHES")
+	// ErrResourceDeleted the patient has been deleted
+	ErrResourceDeleted = errors.New("RESOURCE_DELETED")
+	// ErrInvalidatedResource the patient has been invalidated e.g. merged into another record
+	ErrInvalidatedResource = errors.New("INVALIDATED_RESOURCE")
+)
+
+var outcomeErrors = []error{
+	ErrInvalidResourceID,
+	ErrResourceNotFound,
+	ErrPreconditionFailed,
+	ErrInvalidSearchData,
+	ErrInvalidValue,
+	ErrMissingValue,
+	ErrInvalidUpdate,
+	ErrAccessDenied,
+	ErrTooManyMatches,
+	ErrResourceDeleted,
+	ErrInvalidatedResource,
+}
+
+// patchOutcomeErrors the error codes the PDS responds with when it rejects the patch itself,
+// other codes such as INVALID_RESOURCE_ID or MISSING_VALUE are about the rest of the request
+var patchOutcomeErrors = []error{ErrInvalidUpdate}
+
+// isPatchOutcome reports whether the error is the PDS rejecting a patch
+func isPatchOutcome(outcome *OperationOutcomeError) bool {
+	if outcome == nil {
+		return false
+	}
+	for _, err := range patchOutcomeErrors {
+		if errors.Is(outcome, err) {
+			return true
+		}
+	}
+	return false
+}
+
+// OperationOutcomeError is returned when the API responds with a non 2xx status code.
+// It contains the details of the OperationOutcome resource sent back by the API.
+type OperationOutcomeError struct {
+	// StatusCode the http status code of the response
+	StatusCode int
+	// IssueCodes the FHIR issue types found in issue[].code e.g. value, not-found
+	IssueCodes []string
+	// Codes the NHS error codes found in issue[].details.coding[].code e.g. RESOURCE_NOT_FOUND
+	Codes []string
+	// Diagnostics the diagnostic messages of the issues
+	Diagnostics string
+	// RequestID the id of the request which failed, used for debugging or support
+	RequestID string
+	// Outcome the OperationOutcome returned by the API, nil if the body wasn't an OperationOutcome
+	Outcome *model.OperationOutcome
+	// Response the response received from the API
+	Response *Response
+}
+
+func (e *OperationOutcomeError) Error() string {
+	msg := fmt.Sprintf("request failed with status code: %v", e.StatusCode)
+	if len(e.Codes) > 0 {
+		msg += fmt.Sprintf(", code: %v", strings.Join(e.Codes, ", "))
+	}
+	if e.Diagnostics != "" {
+		msg += fmt.Sprintf(", diagnostics: %v", e.Diagnostics)
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(", request id: %v", e.RequestID)
+	}
+	return msg
+}
+
+// Is reports whether the outcome contains the error code of the target e.g. errors.Is(err, ErrResourceNotFound)
+func (e *OperationOutcomeError) Is(target error) bool {
+	for _, outcomeErr := range outcomeErrors {
+		if target != outcomeErr {
+			continue
+		}
+		for _, code := range e.Codes {
+			if code == outcomeErr.Error() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newOperationOutcomeError reads the OperationOutcome from the body of an unsuccessful response
+func newOperationOutcomeError(resp *Response) *OperationOutcomeError {
+	e := &OperationOutcomeError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.RequestID,
+		Response:   resp,
+	}
+
+	if resp.Body == nil {
+		return e
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || len(body) == 0 {
+		return e
+	}
+
+	outcome := &model.OperationOutcome{}
+	if err := json.Unmarshal(body, outcome); err != nil || outcome.ResourceType != "OperationOutcome" {
+		return e
+	}
+	e.Outcome = outcome
+
+	diagnostics := []string{}
+	for _, issue := range outcome.Issue {
+		e.IssueCodes = append(e.IssueCodes, issue.Code)
+		for _, coding := range issue.Details.Coding {
+			e.Codes = append(e.Codes, coding.Code)
+		}
+		if issue.Diagnostics != "" {
+			diagnostics = append(diagnostics, issue.Diagnostics)
+		}
+	}
+	e.Diagnostics = strings.Join(diagnostics, "; ")
+
+	return e
+}
+
+// isSuccess reports whether the status code is 2xx
+func isSuccess(statusCode int) bool {
+	return statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices
+}
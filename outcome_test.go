@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/welldigital/nhs-fhir/model"
+)
+
+func newOutcome(code, diagnostics string) string {
+	return `{
+		"resourceType": "OperationOutcome",
+		"issue": [
+			{
+				"severity": "error",
+				"code": "value",
+				"details": {
+					"coding": [
+						{
+							"system": "https://fhir.nhs.uk/R4/CodeSystem/Spine-ErrorOrWarningCode",
+							"version": "1",
+							"code": "` + code + `",
+							"display": "display"
+						}
+					]
+				},
+				"diagnostics": "` + diagnostics + `"
+			}
+		]
+	}`
+}
+
+func TestPatientService_errors(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          int
+		body            string
+		call            func(c *Client) error
+		wantSentinel    error
+		wantCodes       []string
+		wantDiagnostics string
+	}{
+		{
+			name:   "get patient not found",
+			status: http.StatusNotFound,
+			body:   newOutcome("RESOURCE_NOT_FOUND", "Resource Id '9000000009' does not exist"),
+			call: func(c *Client) error {
+				_, _, err := c.Patient.Get(context.Background(), "9000000009")
+				return err
+			},
+			wantSentinel:    ErrResourceNotFound,
+			wantCodes:       []string{"RESOURCE_NOT_FOUND"},
+			wantDiagnostics: "Resource Id '9000000009' does not exist",
+		},
+		{
+			name:   "get invalid resource id",
+			status: http.StatusBadRequest,
+			body:   newOutcome("INVALID_RESOURCE_ID", "Invalid resource ID"),
+			call: func(c *Client) error {
+				_, _, err := c.Patient.Get(context.Background(), "9000000009")
+				return err
+			},
+			wantSentinel:    ErrInvalidResourceID,
+			wantCodes:       []string{"INVALID_RESOURCE_ID"},
+			wantDiagnostics: "Invalid resource ID",
+		},
+		{
+			name:   "search with invalid data",
+			status: http.StatusBadRequest,
+			body:   newOutcome("INVALID_SEARCH_DATA", "Invalid value - 'x' in field 'birthdate'"),
+			call: func(c *Client) error {
+				_, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{MaxResults: 1})
+				return err
+			},
+			wantSentinel:    ErrInvalidSearchData,
+			wantCodes:       []string{"INVALID_SEARCH_DATA"},
+			wantDiagnostics: "Invalid value - 'x' in field 'birthdate'",
+		},
+		{
+			name:   "update with old version",
+			status: http.StatusPreconditionFailed,
+			body:   newOutcome("PRECONDITION_FAILED", "Invalid update with error - This resource has changed since you last read"),
+			call: func(c *Client) error {
+				_, _, err := c.Patient.Update(context.Background(), "9000000009", "1", []model.PatchOp{{Op: model.PatchOpRemove, Path: "/birthDate"}})
+				return err
+			},
+			wantSentinel:    ErrPreconditionFailed,
+			wantCodes:       []string{"PRECONDITION_FAILED"},
+			wantDiagnostics: "Invalid update with error - This resource has changed since you last read",
+		},
+		{
+			name:   "body isn't an operation outcome",
+			status: http.StatusForbidden,
+			body:   `<html>Forbidden</html>`,
+			call: func(c *Client) error {
+				_, _, err := c.Patient.Get(context.Background(), "9000000009")
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer svr.Close()
+
+			c, _ := NewClientWithOptions(&Options{Client: svr.Client(), BaseURL: svr.URL + "/"})
+			// updates aren't allowed with application-restricted access
+			c.accessMode = HealthcareWorker
+
+			err := tt.call(c)
+
+			var outcome *OperationOutcomeError
+			if !errors.As(err, &outcome) {
+				t.Fatalf("expected OperationOutcomeError got %v", err)
+			}
+			assert.Equal(t, tt.status, outcome.StatusCode)
+			assert.Equal(t, tt.wantCodes, outcome.Codes)
+			assert.Equal(t, tt.wantDiagnostics, outcome.Diagnostics)
+			assert.NotEmpty(t, outcome.RequestID)
+			if tt.wantSentinel != nil {
+				assert.ErrorIs(t, err, tt.wantSentinel)
+			}
+			assert.False(t, errors.Is(err, ErrAccessDenied))
+		})
+	}
+}
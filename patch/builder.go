@@ -0,0 +1,285 @@
+/*
+
+Package patch builds JSON Patch operations for updating a patient in the PDS.
+
+Array elements are found by their id so you don't need to work out their position
+in the patient, and the test operations that the PDS requires before an element is
+removed or replaced are added for you.
+
+Example:
+
+	ops, err := patch.NewBuilder(p).
+		ReplaceGiven("123", "Jane", "Anne").
+		EndAddress("456", time.Now()).
+		RemoveTelecom("789").
+		SetNominatedPharmacy("Y12345").
+		Build()
+
+	if err != nil {
+		panic(err)
+	}
+
+	updated, _, err := cli.Patient.Update(ctx, p.ID, p.Meta.VersionID, ops)
+
+*/
+
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/welldigital/nhs-fhir/model"
+)
+
+// ErrPatientMissing error for when the builder is created without a patient
+var ErrPatientMissing = errors.New("patient is missing but required")
+
+// ErrElementNotFound error for when an element with the given id does not exist on the patient
+var ErrElementNotFound = errors.New("element not found")
+
+// dateFormat the format of dates in the PDS e.g. 2021-12-31
+const dateFormat = "2006-01-02"
+
+// Builder builds a list of JSON Patch operations against a patient.
+// The builder keeps its own copy of the patient which it updates as operations are added,
+// this keeps the array positions correct when elements are added or removed.
+// If any method fails then the error is returned from Build and the remaining methods are ignored.
+type Builder struct {
+	patient *model.Patient
+	ops     []model.PatchOp
+	err     error
+}
+
+// NewBuilder creates a builder for patching the given patient.
+// The patient should be the latest version retrieved from the PDS.
+func NewBuilder(p *model.Patient) *Builder {
+	b := &Builder{}
+	if p == nil {
+		b.err = ErrPatientMissing
+		return b
+	}
+
+	b.patient, b.err = copyPatient(p)
+	return b
+}
+
+// Build returns the operations that have been added or the first error that occurred
+func (b *Builder) Build() ([]model.PatchOp, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.ops, nil
+}
+
+// ReplaceGiven replaces the given names of the name with the matching id
+func (b *Builder) ReplaceGiven(nameID string, given ...string) *Builder {
+	i, ok := b.nameIndex(nameID)
+	if !ok {
+		return b
+	}
+	b.guard("name", i, nameID)
+	b.add(model.PatchOpReplace, fmt.Sprintf("/name/%d/given", i), given)
+	b.patient.Name[i].Given = given
+	return b
+}
+
+// ReplaceFamily replaces the family name of the name with the matching id
+func (b *Builder) ReplaceFamily(nameID string, family string) *Builder {
+	i, ok := b.nameIndex(nameID)
+	if !ok {
+		return b
+	}
+	b.guard("name", i, nameID)
+	b.add(model.PatchOpReplace, fmt.Sprintf("/name/%d/family", i), family)
+	b.patient.Name[i].Family = family
+	return b
+}
+
+// AddAddress adds a new address to the patient
+func (b *Builder) AddAddress(address model.Address) *Builder {
+	if b.err != nil {
+		return b
+	}
+	b.add(model.PatchOpAdd, "/address/-", address)
+	b.patient.Address = append(b.patient.Address, address)
+	return b
+}
+
+// EndAddress sets the end date of the address with the matching id
+func (b *Builder) EndAddress(addressID string, date time.Time) *Builder {
+	i, ok := b.addressIndex(addressID)
+	if !ok {
+		return b
+	}
+	end := date.Format(dateFormat)
+	b.guard("address", i, addressID)
+	if b.patient.Address[i].Period == (model.Period{}) {
+		// the address has no period to add the end date to so the whole period is added
+		b.add(model.PatchOpAdd, fmt.Sprintf("/address/%d/period", i), model.Period{End: end})
+	} else {
+		// add replaces the value if the end date already exists
+		b.add(model.PatchOpAdd, fmt.Sprintf("/address/%d/period/end", i), end)
+	}
+	b.patient.Address[i].Period.End = end
+	return b
+}
+
+// RemoveAddress removes the address with the matching id
+func (b *Builder) RemoveAddress(addressID string) *Builder {
+	i, ok := b.addressIndex(addressID)
+	if !ok {
+		return b
+	}
+	b.guard("address", i, addressID)
+	b.add(model.PatchOpRemove, fmt.Sprintf("/address/%d", i), nil)
+	b.patient.Address = append(b.patient.Address[:i], b.patient.Address[i+1:]...)
+	return b
+}
+
+// AddTelecom adds a new contact point e.g. phone number or email to the patient
+func (b *Builder) AddTelecom(telecom model.ResourceTelecom) *Builder {
+	if b.err != nil {
+		return b
+	}
+	b.add(model.PatchOpAdd, "/telecom/-", telecom)
+	b.patient.Telecom = append(b.patient.Telecom, telecom)
+	return b
+}
+
+// ReplaceTelecom replaces the value of the contact point with the matching id
+func (b *Builder) ReplaceTelecom(telecomID string, value string) *Builder {
+	i, ok := b.telecomIndex(telecomID)
+	if !ok {
+		return b
+	}
+	b.guard("telecom", i, telecomID)
+	b.add(model.PatchOpReplace, fmt.Sprintf("/telecom/%d/value", i), value)
+	b.patient.Telecom[i].Value = value
+	return b
+}
+
+// RemoveTelecom removes the contact point with the matching id
+func (b *Builder) RemoveTelecom(telecomID string) *Builder {
+	i, ok := b.telecomIndex(telecomID)
+	if !ok {
+		return b
+	}
+	b.guard("telecom", i, telecomID)
+	b.add(model.PatchOpRemove, fmt.Sprintf("/telecom/%d", i), nil)
+	b.patient.Telecom = append(b.patient.Telecom[:i], b.patient.Telecom[i+1:]...)
+	return b
+}
+
+// SetNominatedPharmacy sets the patient's nominated pharmacy using its ODS code e.g. Y12345.
+// The existing nominated pharmacy is replaced if the patient already has one.
+func (b *Builder) SetNominatedPharmacy(ods string) *Builder {
+	if b.err != nil {
+		return b
+	}
+
+	for i, ext := range b.patient.Extension {
+		if ext.URL != model.NominatedPharmacyURL {
+			continue
+		}
+		b.add(model.PatchOpTest, fmt.Sprintf("/extension/%d/url", i), model.NominatedPharmacyURL)
+		if ext.ValueReference == nil {
+			// there's no value to replace so the whole reference is added
+			ref := &model.ValueReference{
+				Identifier: model.IdentifierElement{System: model.ODSOrganizationCodeSystem, Value: ods},
+			}
+			b.add(model.PatchOpAdd, fmt.Sprintf("/extension/%d/valueReference", i), ref)
+			b.patient.Extension[i].ValueReference = ref
+			return b
+		}
+		b.add(model.PatchOpReplace, fmt.Sprintf("/extension/%d/valueReference/identifier/value", i), ods)
+		// copy the reference as it may be shared with an earlier operation
+		ref := *ext.ValueReference
+		ref.Identifier.Value = ods
+		b.patient.Extension[i].ValueReference = &ref
+		return b
+	}
+
+	ext := model.ResourceExtension{
+		URL: model.NominatedPharmacyURL,
+		ValueReference: &model.ValueReference{
+			Identifier: model.IdentifierElement{
+				System: model.ODSOrganizationCodeSystem,
+				Value:  ods,
+			},
+		},
+	}
+	b.add(model.PatchOpAdd, "/extension/-", ext)
+	b.patient.Extension = append(b.patient.Extension, ext)
+	return b
+}
+
+// add appends an operation to the list of operations, empty fields are removed from the value as the PDS rejects them
+func (b *Builder) add(op, path string, value interface{}) {
+	if value != nil {
+		compacted, err := model.CompactValue(value)
+		if err != nil {
+			b.err = err
+			return
+		}
+		value = compacted
+	}
+	b.ops = append(b.ops, model.PatchOp{Op: op, Path: path, Value: value})
+}
+
+// guard adds the test operation the PDS requires before an array element is removed or replaced
+func (b *Builder) guard(field string, index int, id string) {
+	b.add(model.PatchOpTest, fmt.Sprintf("/%s/%d/id", field, index), id)
+}
+
+func (b *Builder) nameIndex(id string) (int, bool) {
+	if b.err != nil {
+		return 0, false
+	}
+	for i, n := range b.patient.Name {
+		if n.ID == id {
+			return i, true
+		}
+	}
+	b.err = fmt.Errorf("%w: name with id %q", ErrElementNotFound, id)
+	return 0, false
+}
+
+func (b *Builder) addressIndex(id string) (int, bool) {
+	if b.err != nil {
+		return 0, false
+	}
+	for i, a := range b.patient.Address {
+		if a.ID == id {
+			return i, true
+		}
+	}
+	b.err = fmt.Errorf("%w: address with id %q", ErrElementNotFound, id)
+	return 0, false
+}
+
+func (b *Builder) telecomIndex(id string) (int, bool) {
+	if b.err != nil {
+		return 0, false
+	}
+	for i, t := range b.patient.Telecom {
+		if t.ID == id {
+			return i, true
+		}
+	}
+	b.err = fmt.Errorf("%w: telecom with id %q", ErrElementNotFound, id)
+	return 0, false
+}
+
+// copyPatient deep copies the patient so the builder doesn't modify the callers patient
+func copyPatient(p *model.Patient) (*model.Patient, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	cp := &model.Patient{}
+	err = json.Unmarshal(b, cp)
+	return cp, err
+}
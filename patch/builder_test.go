@@ -0,0 +1,214 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/welldigital/nhs-fhir/model"
+)
+
+func newPatient() *model.Patient {
+	return &model.Patient{
+		ID:   "9000000009",
+		Name: []model.Name{{ID: "123", Given: []string{"Jane"}, Family: "Smith"}},
+		Address: []model.Address{
+			{ID: "456", Line: []string{"1 Trevelyan Square"}},
+			{ID: "457", Line: []string{"2 Trevelyan Square"}},
+		},
+		Telecom: []model.ResourceTelecom{
+			{ID: "789", System: "phone", Value: "01632960587"},
+			{ID: "790", System: "email", Value: "jane.smith@example.com"},
+		},
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	date := time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		patient *model.Patient
+		build   func(b *Builder) *Builder
+		// want the JSON of the operations sent to the PDS
+		want    string
+		wantErr error
+	}{
+		{
+			name: "replaces given names",
+			build: func(b *Builder) *Builder {
+				return b.ReplaceGiven("123", "Jane", "Anne")
+			},
+			want: `[
+				{"op": "test", "path": "/name/0/id", "value": "123"},
+				{"op": "replace", "path": "/name/0/given", "value": ["Jane", "Anne"]}
+			]`,
+		},
+		{
+			name: "replaces family name",
+			build: func(b *Builder) *Builder {
+				return b.ReplaceFamily("123", "Jones")
+			},
+			want: `[
+				{"op": "test", "path": "/name/0/id", "value": "123"},
+				{"op": "replace", "path": "/name/0/family", "value": "Jones"}
+			]`,
+		},
+		{
+			name: "adds an address without its empty fields",
+			build: func(b *Builder) *Builder {
+				return b.AddAddress(model.Address{Use: "home", PostalCode: "LS1 6AE"})
+			},
+			want: `[
+				{"op": "add", "path": "/address/-", "value": {"use": "home", "postalCode": "LS1 6AE"}}
+			]`,
+		},
+		{
+			name: "ends an address without a period",
+			build: func(b *Builder) *Builder {
+				return b.EndAddress("457", date)
+			},
+			want: `[
+				{"op": "test", "path": "/address/1/id", "value": "457"},
+				{"op": "add", "path": "/address/1/period", "value": {"end": "2021-12-31"}}
+			]`,
+		},
+		{
+			name: "ends an address with a period",
+			patient: func() *model.Patient {
+				p := newPatient()
+				p.Address[1].Period.Start = "2020-01-01"
+				return p
+			}(),
+			build: func(b *Builder) *Builder {
+				return b.EndAddress("457", date)
+			},
+			want: `[
+				{"op": "test", "path": "/address/1/id", "value": "457"},
+				{"op": "add", "path": "/address/1/period/end", "value": "2021-12-31"}
+			]`,
+		},
+		{
+			name: "ends an address twice",
+			build: func(b *Builder) *Builder {
+				return b.EndAddress("457", date).EndAddress("457", date.AddDate(0, 0, 1))
+			},
+			want: `[
+				{"op": "test", "path": "/address/1/id", "value": "457"},
+				{"op": "add", "path": "/address/1/period", "value": {"end": "2021-12-31"}},
+				{"op": "test", "path": "/address/1/id", "value": "457"},
+				{"op": "add", "path": "/address/1/period/end", "value": "2022-01-01"}
+			]`,
+		},
+		{
+			name: "positions are kept up to date after a remove",
+			build: func(b *Builder) *Builder {
+				return b.RemoveTelecom("789").ReplaceTelecom("790", "jane@example.com")
+			},
+			want: `[
+				{"op": "test", "path": "/telecom/0/id", "value": "789"},
+				{"op": "remove", "path": "/telecom/0"},
+				{"op": "test", "path": "/telecom/0/id", "value": "790"},
+				{"op": "replace", "path": "/telecom/0/value", "value": "jane@example.com"}
+			]`,
+		},
+		{
+			name: "removes an address",
+			build: func(b *Builder) *Builder {
+				return b.RemoveAddress("456")
+			},
+			want: `[
+				{"op": "test", "path": "/address/0/id", "value": "456"},
+				{"op": "remove", "path": "/address/0"}
+			]`,
+		},
+		{
+			name: "adds a telecom without its empty fields",
+			build: func(b *Builder) *Builder {
+				return b.AddTelecom(model.ResourceTelecom{System: "email", Value: "jane@example.com", Use: "home"})
+			},
+			want: `[
+				{"op": "add", "path": "/telecom/-", "value": {"system": "email", "value": "jane@example.com", "use": "home"}}
+			]`,
+		},
+		{
+			name: "adds a nominated pharmacy",
+			build: func(b *Builder) *Builder {
+				return b.SetNominatedPharmacy("Y12345")
+			},
+			want: `[
+				{"op": "add", "path": "/extension/-", "value": {
+					"url": "` + model.NominatedPharmacyURL + `",
+					"valueReference": {"identifier": {"system": "` + model.ODSOrganizationCodeSystem + `", "value": "Y12345"}}
+				}}
+			]`,
+		},
+		{
+			name: "replaces an existing nominated pharmacy",
+			build: func(b *Builder) *Builder {
+				return b.SetNominatedPharmacy("Y12345").SetNominatedPharmacy("Y23456")
+			},
+			want: `[
+				{"op": "add", "path": "/extension/-", "value": {
+					"url": "` + model.NominatedPharmacyURL + `",
+					"valueReference": {"identifier": {"system": "` + model.ODSOrganizationCodeSystem + `", "value": "Y12345"}}
+				}},
+				{"op": "test", "path": "/extension/0/url", "value": "` + model.NominatedPharmacyURL + `"},
+				{"op": "replace", "path": "/extension/0/valueReference/identifier/value", "value": "Y23456"}
+			]`,
+		},
+		{
+			name: "adds the reference of a nominated pharmacy extension without one",
+			patient: &model.Patient{
+				ID:        "9000000009",
+				Extension: []model.ResourceExtension{{URL: model.NominatedPharmacyURL}},
+			},
+			build: func(b *Builder) *Builder {
+				return b.SetNominatedPharmacy("Y12345")
+			},
+			want: `[
+				{"op": "test", "path": "/extension/0/url", "value": "` + model.NominatedPharmacyURL + `"},
+				{"op": "add", "path": "/extension/0/valueReference", "value": {
+					"identifier": {"system": "` + model.ODSOrganizationCodeSystem + `", "value": "Y12345"}
+				}}
+			]`,
+		},
+		{
+			name: "unknown id",
+			build: func(b *Builder) *Builder {
+				return b.RemoveTelecom("000").ReplaceGiven("123", "Jane")
+			},
+			want:    `null`,
+			wantErr: ErrElementNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patient := tt.patient
+			if patient == nil {
+				patient = newPatient()
+			}
+			got, err := tt.build(NewBuilder(patient)).Build()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Builder.Build() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			sent, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("couldnt marshal operations: %v", err)
+			}
+			assert.JSONEq(t, tt.want, string(sent))
+		})
+	}
+}
+
+func TestNewBuilder(t *testing.T) {
+	_, err := NewBuilder(nil).ReplaceGiven("123", "Jane").Build()
+	assert.ErrorIs(t, err, ErrPatientMissing)
+
+	p := newPatient()
+	_, err = NewBuilder(p).RemoveTelecom("789").Build()
+	assert.NoError(t, err)
+	assert.Len(t, p.Telecom, 2, "builder should not modify the patient")
+}
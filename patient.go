@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -14,21 +15,35 @@ type PatientService = service
 
 const path = "personal-demographics/FHIR/R4/Patient"
 
+// ErrVersionMissing error for when the patient version is missing on an update
+var ErrVersionMissing = errors.New("patient version is missing but required")
+
+// ErrPatchEmpty error for when an update doesn't contain any patch operations
+var ErrPatchEmpty = errors.New("patch must contain at least one operation")
+
 // Get gets a patient from the PDS using the patients NHS number as the id.
 // id = The patient's NHS number. The primary identifier of a patient, unique within NHS England and Wales. Always 10 digits and must be a valid NHS number.
-func (p *PatientService) Get(ctx context.Context, id string) (*model.Patient, *Response, error) {
-	err := validation.NhsNumberValidator(id)
+// An OperationOutcomeError is returned if the PDS responds with an error e.g. errors.Is(err, ErrResourceNotFound)
+// With patient access an AccessModeError is returned if the id isn't the NHS number of the logged in patient.
+func (p *PatientService) Get(ctx context.Context, id string) (patient *model.Patient, resp *Response, err error) {
+	ctx, span := p.telemetry.start(ctx, SpanPatientGet)
+	defer func() { endSpan(span, err) }()
+
+	err = validation.NhsNumberValidator(id)
 	if err != nil {
 		return nil, nil, err
 	}
-	req, err := p.client.newRequest(http.MethodGet, fmt.Sprintf(path+"/%v", id), nil)
+	if err := p.client.accessGetter().checkGet(id); err != nil {
+		return nil, nil, err
+	}
+	req, err := p.client.newRequest(ctx, http.MethodGet, fmt.Sprintf(path+"/%v", id), nil)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
-	patient := &model.Patient{}
-	resp, err := p.client.do(ctx, req, patient)
+	patient = &model.Patient{}
+	resp, err = p.client.do(ctx, req, patient)
 
 	if err != nil {
 		return nil, resp, err
@@ -47,7 +62,7 @@ type PatientSearchOptions struct {
 	// The search looks for matches in historic information such as previous names and addresses.
 	// This parameter has no effect for a fuzzy search, which always includes historic information.
 	History *bool `url:"_history,omitempty"`
-	// For application-restricted access, this must be 1
+	// For application-restricted access, this must be 1. For healthcare worker access, this must be at most 50
 	MaxResults int `url:"_max-results"`
 	// if used with wildcards, fuzzy match must be false. Wildcards must contain at least two characters, this matches Smith, Smythe. Not case-sensitive.
 	Family *string `url:"family,omitempty"`
@@ -69,16 +84,25 @@ type PatientSearchOptions struct {
 }
 
 // Search searches for a patient in the PDS
+// An OperationOutcomeError is returned if the PDS responds with an error e.g. errors.Is(err, ErrInvalidSearchData)
 // The behaviour of this endpoint depends on your access mode:
 //https://digital.nhs.uk/developer/api-catalogue/personal-demographics-service-fhir#api-Default-search-patient
-func (p *PatientService) Search(ctx context.Context, opts PatientSearchOptions) ([]*model.Patient, *Response, error) {
+// An AccessModeError is returned if MaxResults is above the limit of your access mode or when using patient access.
+func (p *PatientService) Search(ctx context.Context, opts PatientSearchOptions) (patients []*model.Patient, resp *Response, err error) {
+	ctx, span := p.telemetry.start(ctx, SpanPatientSearch)
+	defer func() { endSpan(span, err) }()
+
+	if err := p.client.accessGetter().checkSearch(opts); err != nil {
+		return nil, nil, err
+	}
+
 	url, err := addParamsToURL(path, opts)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
-	req, err := p.client.newRequest(http.MethodGet, url, nil)
+	req, err := p.client.newRequest(ctx, http.MethodGet, url, nil)
 
 	if err != nil {
 		return nil, nil, err
@@ -86,18 +110,82 @@ func (p *PatientService) Search(ctx context.Context, opts PatientSearchOptions)
 
 	result := &model.Result{}
 
-	resp, err := p.client.do(ctx, req, result)
+	resp, err = p.client.do(ctx, req, result)
 
 	if err != nil {
 		return nil, resp, err
 	}
 
-	patients := make([]*model.Patient, len(result.Entry))
+	patients = make([]*model.Patient, len(result.Entry))
 
 	for i, entry := range result.Entry {
 		patients[i] = &entry.Resource
 	}
+	span.SetAttributes(AttrResultCount.Int(len(patients)))
+	p.metrics.searchScores(result)
 
 	return patients, resp, nil
 
 }
+
+// Update partially updates a patient in the PDS by applying a JSON Patch to the patient.
+// nhsNumber = The patient's NHS number.
+// version = The version of the patient the patch was made against, this is found in model.Meta.VersionID.
+// If the patient has been updated since then a VersionConflictError is returned.
+// Updates require user-restricted access, an AccessModeError is returned otherwise.
+// With patient access only the patient's own telecom and extensions can be updated.
+// https://digital.nhs.uk/developer/api-catalogue/personal-demographics-service-fhir#api-Default-update-patient-partial
+func (p *PatientService) Update(ctx context.Context, nhsNumber string, version string, patch []model.PatchOp) (patient *model.Patient, resp *Response, err error) {
+	ctx, span := p.telemetry.start(ctx, SpanPatientUpdate)
+	defer func() { endSpan(span, err) }()
+
+	err = validation.NhsNumberValidator(nhsNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == "" {
+		return nil, nil, ErrVersionMissing
+	}
+	if len(patch) == 0 {
+		return nil, nil, ErrPatchEmpty
+	}
+	if err := p.client.accessGetter().checkUpdate(nhsNumber, patch); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := p.client.newRequest(
+		ctx,
+		http.MethodPatch,
+		fmt.Sprintf(path+"/%v", nhsNumber),
+		model.Patch{Patches: patch},
+		withContentType("application/json-patch+json"),
+		withHeader("If-Match", fmt.Sprintf("W/%q", version)),
+	)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	patient = &model.Patient{}
+	resp, err = p.client.do(ctx, req, patient)
+
+	if resp != nil && resp.Response != nil {
+		var outcome *OperationOutcomeError
+		errors.As(err, &outcome)
+
+		switch resp.StatusCode {
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			return nil, resp, &VersionConflictError{Version: version, Response: resp, Outcome: outcome}
+		case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			if isPatchOutcome(outcome) {
+				return nil, resp, &InvalidPatchError{Response: resp, Outcome: outcome}
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return patient, resp, nil
+}
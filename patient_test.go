@@ -12,7 +12,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/welldigital/nhs-fhir/model"
 )
@@ -53,18 +52,11 @@ func TestPatientService_Get(t *testing.T) {
 			},
 			p: &service{
 				client: &Client{
-					withAuth: true,
+					tokenSource: StaticTokenSource("token"),
 					BaseURL: &url.URL{
 						Scheme: "https",
 						Host:   "test.com",
 					},
-					accessToken: AccessTokenResponse{
-						AccessToken: "token",
-						ExpiresIn:   600,
-						TokenType:   "bearer",
-						IssuedAt:    time.Now().UnixNano() / int64(time.Millisecond),
-					},
-					jwt: "jwt",
 					httpClient: &http.Client{
 						Timeout: 1 * time.Millisecond,
 						Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
@@ -78,14 +70,6 @@ func TestPatientService_Get(t *testing.T) {
 							return &http.Response{}, nil
 						}),
 					},
-					authConfig: &AuthConfigOptions{
-						BaseURL:  "https://test.com",
-						ClientID: "123",
-						Kid:      "1",
-						Signer: func(token *jwt.Token, key interface{}) (string, error) {
-							return "", nil
-						},
-					},
 				},
 			},
 		},
@@ -94,10 +78,13 @@ func TestPatientService_Get(t *testing.T) {
 			name: "invalid nhs number",
 			p: &service{
 				client: &IClientMock{
+					accessGetterFunc: func() access {
+						return access{mode: ApplicationRestricted}
+					},
 					doFunc: func(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 						return &Response{}, nil
 					},
-					newRequestFunc: func(method, path string, body interface{}) (*http.Request, error) {
+					newRequestFunc: func(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 						return &http.Request{}, nil
 					},
 				},
@@ -112,10 +99,13 @@ func TestPatientService_Get(t *testing.T) {
 			name: "bad request",
 			p: &service{
 				client: &IClientMock{
+					accessGetterFunc: func() access {
+						return access{mode: ApplicationRestricted}
+					},
 					doFunc: func(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 						return &Response{}, nil
 					},
-					newRequestFunc: func(method, path string, body interface{}) (*http.Request, error) {
+					newRequestFunc: func(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 						return &http.Request{}, errors.New("bang")
 					},
 				},
@@ -129,11 +119,14 @@ func TestPatientService_Get(t *testing.T) {
 		{
 			name: "bad response",
 			p: &service{
-				&IClientMock{
+				client: &IClientMock{
+					accessGetterFunc: func() access {
+						return access{mode: ApplicationRestricted}
+					},
 					doFunc: func(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 						return &Response{}, errors.New("fail")
 					},
-					newRequestFunc: func(method, path string, body interface{}) (*http.Request, error) {
+					newRequestFunc: func(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 						return &http.Request{}, nil
 					},
 				},
@@ -148,6 +141,9 @@ func TestPatientService_Get(t *testing.T) {
 			name: "gets a dummy patient from sandbox",
 			p: &service{
 				client: &IClientMock{
+					accessGetterFunc: func() access {
+						return access{mode: ApplicationRestricted}
+					},
 					doFunc: func(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 						patient := `{
 							"resourceType": "Patient",
@@ -469,7 +465,7 @@ func TestPatientService_Get(t *testing.T) {
 						err := json.NewDecoder(r).Decode(v)
 						return newResponse(&http.Response{Status: "200", Body: r}), err
 					},
-					newRequestFunc: func(method, path string, body interface{}) (*http.Request, error) {
+					newRequestFunc: func(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 						assert.Equal(t, path, "personal-demographics/FHIR/R4/Patient/2983396339")
 						url, err := url.Parse(path)
 						if err != nil {
@@ -822,11 +818,14 @@ func TestPatientService_Search(t *testing.T) {
 		{
 			name: "user not found",
 			p: &service{
-				&IClientMock{
+				client: &IClientMock{
+					accessGetterFunc: func() access {
+						return access{mode: ApplicationRestricted}
+					},
 					doFunc: func(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 						return &Response{}, nil
 					},
-					newRequestFunc: func(method, path string, body interface{}) (*http.Request, error) {
+					newRequestFunc: func(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 						return &http.Request{}, nil
 					},
 				},
@@ -841,11 +840,14 @@ func TestPatientService_Search(t *testing.T) {
 		{
 			name: "bad request",
 			p: &service{
-				&IClientMock{
+				client: &IClientMock{
+					accessGetterFunc: func() access {
+						return access{mode: ApplicationRestricted}
+					},
 					doFunc: func(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 						return &Response{}, nil
 					},
-					newRequestFunc: func(method, path string, body interface{}) (*http.Request, error) {
+					newRequestFunc: func(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 						return &http.Request{}, errors.New("bad request")
 					},
 				},
@@ -859,11 +861,14 @@ func TestPatientService_Search(t *testing.T) {
 		{
 			name: "bad response",
 			p: &service{
-				&IClientMock{
+				client: &IClientMock{
+					accessGetterFunc: func() access {
+						return access{mode: ApplicationRestricted}
+					},
 					doFunc: func(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 						return &Response{}, errors.New("bad response")
 					},
-					newRequestFunc: func(method, path string, body interface{}) (*http.Request, error) {
+					newRequestFunc: func(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 						return &http.Request{}, nil
 					},
 				},
@@ -877,7 +882,10 @@ func TestPatientService_Search(t *testing.T) {
 		{
 			name: "finds a patient",
 			p: &service{
-				&IClientMock{
+				client: &IClientMock{
+					accessGetterFunc: func() access {
+						return access{mode: ApplicationRestricted}
+					},
 					doFunc: func(ctx context.Context, req *http.Request, v interface{}) (*Response, error) {
 						results := `{
 							"resourceType": "Bundle",
@@ -933,7 +941,7 @@ func TestPatientService_Search(t *testing.T) {
 						err := json.NewDecoder(r).Decode(v)
 						return newResponse(&http.Response{Status: "200", Body: r}), err
 					},
-					newRequestFunc: func(method, path string, body interface{}) (*http.Request, error) {
+					newRequestFunc: func(ctx context.Context, method, path string, body interface{}, opts ...requestOption) (*http.Request, error) {
 						assert.Equal(t, http.MethodGet, method)
 						assert.Equal(t, "personal-demographics/FHIR/R4/Patient?_fuzzy-match=true&_max-results=1&address-postcode=M123&birthdate=lt2021-01-01&birthdate=ge2020-10-02&given=Smith", path)
 						return &http.Request{}, nil
@@ -1006,3 +1014,123 @@ func TestPatientService_Search(t *testing.T) {
 		})
 	}
 }
+
+func TestPatientService_Update(t *testing.T) {
+	patch := []model.PatchOp{
+		{Op: model.PatchOpReplace, Path: "/gender", Value: "male"},
+	}
+
+	newTestClient := func(status int, body string, check func(r *http.Request)) *Client {
+		return &Client{
+			BaseURL:    &url.URL{Scheme: "https", Host: "test.com", Path: "/"},
+			accessMode: HealthcareWorker,
+			httpClient: &http.Client{
+				Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					if check != nil {
+						check(r)
+					}
+					return &http.Response{
+						StatusCode: status,
+						Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+						Header:     http.Header{},
+					}, nil
+				}),
+			},
+		}
+	}
+
+	type args struct {
+		nhsNumber string
+		version   string
+		patch     []model.PatchOp
+	}
+	tests := []struct {
+		name    string
+		client  IClient
+		args    args
+		want    *model.Patient
+		wantErr error
+	}{
+		{
+			name:    "invalid nhs number",
+			client:  &IClientMock{},
+			args:    args{nhsNumber: "123", version: "1", patch: patch},
+			wantErr: errors.New("invalid"),
+		},
+		{
+			name:    "missing version",
+			client:  &IClientMock{},
+			args:    args{nhsNumber: "9000000009", patch: patch},
+			wantErr: ErrVersionMissing,
+		},
+		{
+			name:    "empty patch",
+			client:  &IClientMock{},
+			args:    args{nhsNumber: "9000000009", version: "2"},
+			wantErr: ErrPatchEmpty,
+		},
+		{
+			name: "sends a json patch with the version",
+			client: newTestClient(http.StatusOK, `{"id":"9000000009","gender":"male","meta":{"versionId":"3"}}`, func(r *http.Request) {
+				assert.Equal(t, http.MethodPatch, r.Method)
+				assert.Equal(t, "/"+path+"/9000000009", r.URL.Path)
+				assert.Equal(t, "application/json-patch+json", r.Header.Get("Content-Type"))
+				assert.Equal(t, `W/"2"`, r.Header.Get("If-Match"))
+				body, _ := ioutil.ReadAll(r.Body)
+				assert.JSONEq(t, `{"patches":[{"op":"replace","path":"/gender","value":"male"}]}`, string(body))
+			}),
+			args: args{nhsNumber: "9000000009", version: "2", patch: patch},
+			want: &model.Patient{ID: "9000000009", Gender: "male", Meta: model.Meta{VersionID: "3"}},
+		},
+		{
+			name:    "version conflict",
+			client:  newTestClient(http.StatusPreconditionFailed, `{"resourceType":"OperationOutcome"}`, nil),
+			args:    args{nhsNumber: "9000000009", version: "1", patch: patch},
+			wantErr: &VersionConflictError{},
+		},
+		{
+			name:    "invalid patch",
+			client:  newTestClient(http.StatusBadRequest, newOutcome("INVALID_UPDATE", ""), nil),
+			args:    args{nhsNumber: "9000000009", version: "2", patch: patch},
+			wantErr: &InvalidPatchError{},
+		},
+		{
+			name:    "invalid resource id isn't an invalid patch",
+			client:  newTestClient(http.StatusBadRequest, newOutcome("INVALID_RESOURCE_ID", ""), nil),
+			args:    args{nhsNumber: "9000000009", version: "2", patch: patch},
+			wantErr: &OperationOutcomeError{},
+		},
+		{
+			name:    "missing value isn't an invalid patch",
+			client:  newTestClient(http.StatusBadRequest, newOutcome("MISSING_VALUE", ""), nil),
+			args:    args{nhsNumber: "9000000009", version: "2", patch: patch},
+			wantErr: &OperationOutcomeError{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &service{client: tt.client}
+			got, _, err := p.Update(context.Background(), tt.args.nhsNumber, tt.args.version, tt.args.patch)
+
+			switch want := tt.wantErr.(type) {
+			case nil:
+				assert.NoError(t, err)
+			case *VersionConflictError:
+				assert.True(t, errors.As(err, &want), "expected VersionConflictError got %v", err)
+			case *InvalidPatchError:
+				assert.True(t, errors.As(err, &want), "expected InvalidPatchError got %v", err)
+			case *OperationOutcomeError:
+				var patchErr *InvalidPatchError
+				assert.True(t, errors.As(err, &want), "expected OperationOutcomeError got %v", err)
+				assert.False(t, errors.As(err, &patchErr), "expected the error not to be an InvalidPatchError")
+			default:
+				if errors.Is(want, ErrVersionMissing) || errors.Is(want, ErrPatchEmpty) {
+					assert.ErrorIs(t, err, want)
+				} else {
+					assert.Error(t, err)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
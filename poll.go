@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrAccepted is returned when the API accepted the request but the result isn't ready yet and manual polling is enabled.
+// Use the Poller found on the response to get the result.
+var ErrAccepted = errors.New("request accepted but the result is not ready yet")
+
+// ErrPollingLocationMissing error for when a 202 Accepted response doesn't contain a Content-Location header
+var ErrPollingLocationMissing = errors.New("accepted response is missing the Content-Location header")
+
+// ErrPollingLocationForeign error for when the Content-Location of a 202 Accepted response isn't on the host of the API,
+// it isn't polled so the access token is never sent to another host
+var ErrPollingLocationForeign = errors.New("accepted response's Content-Location is not on the host of the API")
+
+// ErrPollingTimeout error for when the result isn't ready within the maximum wait time
+var ErrPollingTimeout = errors.New("timed out waiting for the result of an accepted request")
+
+const (
+	defaultPollingMaxWait  = 30 * time.Second
+	defaultPollingInterval = time.Second
+)
+
+// Poller polls for the result of a request that was accepted by the API with a 202 Accepted response
+type Poller struct {
+	client     *Client
+	location   *url.URL
+	retryAfter time.Duration
+}
+
+// newPoller creates a poller from the Content-Location and Retry-After headers of an accepted response
+func (c *Client) newPoller(resp *http.Response) (*Poller, error) {
+	location := resp.Header.Get("Content-Location")
+	if location == "" {
+		return nil, ErrPollingLocationMissing
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	base := c.baseURLGetter()
+	resolved := base.ResolveReference(u)
+	if resolved.Scheme != base.Scheme || !strings.EqualFold(resolved.Host, base.Host) {
+		return nil, fmt.Errorf("%w: %v://%v", ErrPollingLocationForeign, resolved.Scheme, resolved.Host)
+	}
+
+	return &Poller{
+		client:     c,
+		location:   resolved,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}, nil
+}
+
+// Location the url that is polled for the result
+func (p *Poller) Location() string {
+	return p.location.String()
+}
+
+// RetryAfter the time the API asked us to wait before polling again
+func (p *Poller) RetryAfter() time.Duration {
+	return p.retryAfter
+}
+
+// Poll checks once whether the result is ready. If done is true then the result has been decoded into v.
+func (p *Poller) Poll(ctx context.Context, v interface{}) (done bool, resp *Response, err error) {
+	if ctx == nil {
+		return false, nil, errNonNilContext
+	}
+
+	req, err := p.client.newRequest(ctx, http.MethodGet, "", nil, withURL(p.location))
+	if err != nil {
+		return false, nil, err
+	}
+
+	stats := &sendStats{}
+	res, err := p.client.send(ctx, req, stats)
+	if err != nil {
+		return false, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusAccepted {
+		p.retryAfter = parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+		r := newResponse(res)
+		r.RequestID = req.Header.Get("X-Request-ID")
+		stats.apply(r)
+		return false, r, nil
+	}
+
+	resp, err = p.client.decode(req, res, v)
+	if resp != nil {
+		stats.apply(resp)
+	}
+	return true, resp, err
+}
+
+// Wait polls until the result is ready and decodes it into v. It honours the Retry-After header sent by the API
+// and gives up when the context is cancelled or the maximum wait time configured in PollingOptions has passed.
+// A Retry-After beyond the maximum wait time is cut short so the result is polled once more before giving up.
+func (p *Poller) Wait(ctx context.Context, v interface{}) (*Response, error) {
+	if ctx == nil {
+		return nil, errNonNilContext
+	}
+
+	maxWait, interval := defaultPollingMaxWait, defaultPollingInterval
+	if cfg := p.client.pollingConfig; cfg != nil {
+		if cfg.MaxWait > 0 {
+			maxWait = cfg.MaxWait
+		}
+		if cfg.Interval > 0 {
+			interval = cfg.Interval
+		}
+	}
+	deadline := time.Now().Add(maxWait)
+
+	for {
+		delay := interval
+		if p.retryAfter > 0 {
+			delay = p.retryAfter
+		}
+
+		// the last poll is made at the deadline rather than giving up early, the result may be ready by then
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, ErrPollingTimeout
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		done, resp, err := p.Poll(ctx, v)
+		if done || err != nil {
+			return resp, err
+		}
+	}
+}
+
+// parseRetryAfter parses the Retry-After header which is either a number of seconds or a http date.
+// Returns 0 if the header is empty or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
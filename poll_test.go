@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newPollingServer returns a server which accepts the request and returns the result after the given number of polls
+func newPollingServer(pollsUntilReady int32) *httptest.Server {
+	var polls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/poll" {
+			w.Header().Set("Content-Location", "/poll")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if atomic.AddInt32(&polls, 1) < pollsUntilReady {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"Foo":"foo"}`))
+	}))
+}
+
+func newPollingClient(t *testing.T, svr *httptest.Server, opts *PollingOptions) *Client {
+	return newTestClient(t, svr, &Options{PollingOptions: opts, DisableAuth: true})
+}
+
+func TestDo_polling(t *testing.T) {
+	type Result struct {
+		Foo string
+	}
+
+	tests := []struct {
+		name            string
+		opts            *PollingOptions
+		pollsUntilReady int32
+		want            Result
+		wantErr         error
+	}{
+		{
+			name:            "polls until the result is ready",
+			opts:            &PollingOptions{Interval: time.Millisecond, MaxWait: time.Second},
+			pollsUntilReady: 3,
+			want:            Result{Foo: "foo"},
+		},
+		{
+			name:            "gives up after the max wait",
+			opts:            &PollingOptions{Interval: 10 * time.Millisecond, MaxWait: 25 * time.Millisecond},
+			pollsUntilReady: 100,
+			wantErr:         ErrPollingTimeout,
+		},
+		{
+			name:            "manual polling returns a poller",
+			opts:            &PollingOptions{Manual: true, Interval: time.Millisecond},
+			pollsUntilReady: 1,
+			wantErr:         ErrAccepted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := newPollingServer(tt.pollsUntilReady)
+			defer svr.Close()
+			c := newPollingClient(t, svr, tt.opts)
+
+			req, err := c.newRequest(context.Background(), http.MethodPatch, "foo", nil)
+			assert.NoError(t, err)
+
+			result := Result{}
+			resp, err := c.do(context.Background(), req, &result)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, result)
+
+			if tt.opts.Manual {
+				assert.Equal(t, svr.URL+"/poll", resp.Poller.Location())
+				resp, err = resp.Poller.Wait(context.Background(), &result)
+				assert.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, Result{Foo: "foo"}, result)
+			}
+		})
+	}
+}
+
+func TestPoller_Wait_contextCancelled(t *testing.T) {
+	svr := newPollingServer(100)
+	defer svr.Close()
+	c := newPollingClient(t, svr, &PollingOptions{Interval: time.Millisecond, MaxWait: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	u, _ := url.Parse(svr.URL + "/poll")
+	p := &Poller{client: c, location: u}
+	_, err := p.Wait(ctx, &struct{}{})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestDo_pollingLocationMissing(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer svr.Close()
+	c := newPollingClient(t, svr, nil)
+
+	req, _ := c.newRequest(context.Background(), http.MethodGet, "foo", nil)
+	_, err := c.do(context.Background(), req, &struct{}{})
+
+	assert.ErrorIs(t, err, ErrPollingLocationMissing)
+}
+
+func TestDo_pollingLocationForeign(t *testing.T) {
+	var polled bool
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polled = true
+	}))
+	defer foreign.Close()
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Location", foreign.URL+"/poll")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer svr.Close()
+	c := newPollingClient(t, svr, &PollingOptions{Interval: time.Millisecond})
+
+	req, _ := c.newRequest(context.Background(), http.MethodGet, "foo", nil)
+	_, err := c.do(context.Background(), req, &struct{}{})
+
+	assert.ErrorIs(t, err, ErrPollingLocationForeign)
+	assert.False(t, polled)
+}
+
+func TestPoller_Wait_retryAfterBeyondMaxWait(t *testing.T) {
+	svr := newPollingServer(1)
+	defer svr.Close()
+	c := newPollingClient(t, svr, &PollingOptions{Interval: time.Millisecond, MaxWait: 20 * time.Millisecond})
+
+	// the result is polled at the deadline instead of timing out without asking
+	u, _ := url.Parse(svr.URL + "/poll")
+	p := &Poller{client: c, location: u, retryAfter: time.Minute}
+	result := struct{ Foo string }{}
+	resp, err := p.Wait(context.Background(), &result)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "foo", result.Foo)
+
+	notReady := newPollingServer(100)
+	defer notReady.Close()
+	c = newPollingClient(t, notReady, &PollingOptions{Interval: time.Millisecond, MaxWait: 20 * time.Millisecond})
+	u, _ = url.Parse(notReady.URL + "/poll")
+	p = &Poller{client: c, location: u, retryAfter: time.Minute}
+	_, err = p.Wait(context.Background(), &result)
+
+	assert.ErrorIs(t, err, ErrPollingTimeout)
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 12, 8, 14, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{name: "empty", header: "", want: 0},
+		{name: "seconds", header: "3", want: 3 * time.Second},
+		{name: "negative seconds", header: "-3", want: 0},
+		{name: "http date", header: now.Add(time.Minute).Format(http.TimeFormat), want: time.Minute},
+		{name: "date in the past", header: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{name: "invalid", header: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRetryAfter(tt.header, now))
+		})
+	}
+}
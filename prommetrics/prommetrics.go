@@ -0,0 +1,140 @@
+// Package prommetrics records the Metrics of the nhs-fhir client with Prometheus
+package prommetrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	client "github.com/welldigital/nhs-fhir"
+)
+
+// Namespace the prefix of every metric name
+const Namespace = "nhs_fhir"
+
+// Metrics records the measurements of the client as Prometheus metrics, create it with New
+type Metrics struct {
+	requests       *prometheus.CounterVec
+	latency        *prometheus.HistogramVec
+	retries        *prometheus.CounterVec
+	rateLimited    *prometheus.CounterVec
+	rateLimitWait  prometheus.Histogram
+	tokenRefreshes *prometheus.CounterVec
+	tokenLatency   prometheus.Histogram
+	tokenAge       prometheus.Gauge
+	searchScore    prometheus.Histogram
+}
+
+// New creates the metrics and registers them with the registerer e.g. prometheus.DefaultRegisterer.
+// An error is returned if they're already registered, share one Metrics between clients instead.
+func New(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "requests_total",
+			Help:      "The number of requests sent to the API and NHS auth by operation and status class.",
+		}, []string{"operation", "status_class"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "request_duration_seconds",
+			Help:      "The time taken to get a response from the API and NHS auth by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "retries_total",
+			Help:      "The number of requests sent again by operation.",
+		}, []string{"operation"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "rate_limited_total",
+			Help:      "The number of 429 Too Many Requests responses by operation.",
+		}, []string{"operation"}),
+		rateLimitWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "rate_limit_wait_seconds",
+			Help:      "The time spent waiting on the client side rate limiter.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		tokenRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "token_refreshes_total",
+			Help:      "The number of new access tokens requested by result.",
+		}, []string{"result"}),
+		tokenLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "token_refresh_duration_seconds",
+			Help:      "The time taken to get a new access token.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		tokenAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "token_age_seconds",
+			Help:      "The age of the last access token sent with a request.",
+		}),
+		searchScore: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "search_score",
+			Help:      "The score of the patients found by searches, 1 is an exact match.",
+			Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+		}),
+	}
+
+	for _, c := range m.collectors() {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// collectors returns every metric
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requests, m.latency, m.retries, m.rateLimited, m.rateLimitWait,
+		m.tokenRefreshes, m.tokenLatency, m.tokenAge, m.searchScore,
+	}
+}
+
+var _ client.Metrics = &Metrics{}
+
+// ObserveRequest counts the request under requests_total and records its latency in request_duration_seconds
+func (m *Metrics) ObserveRequest(operation, statusClass string, latency time.Duration) {
+	m.requests.WithLabelValues(operation, statusClass).Inc()
+	m.latency.WithLabelValues(operation).Observe(latency.Seconds())
+}
+
+// IncRetry counts the retry under retries_total
+func (m *Metrics) IncRetry(operation string) {
+	m.retries.WithLabelValues(operation).Inc()
+}
+
+// IncRateLimited counts the 429 response under rate_limited_total
+func (m *Metrics) IncRateLimited(operation string) {
+	m.rateLimited.WithLabelValues(operation).Inc()
+}
+
+// ObserveRateLimitWait records the wait in rate_limit_wait_seconds
+func (m *Metrics) ObserveRateLimitWait(wait time.Duration) {
+	m.rateLimitWait.Observe(wait.Seconds())
+}
+
+// ObserveTokenRefresh counts the refresh under token_refreshes_total with a result of success or error
+// and records its latency in token_refresh_duration_seconds
+func (m *Metrics) ObserveTokenRefresh(latency time.Duration, failed bool) {
+	result := "success"
+	if failed {
+		result = "error"
+	}
+	m.tokenRefreshes.WithLabelValues(result).Inc()
+	m.tokenLatency.Observe(latency.Seconds())
+}
+
+// SetTokenAge sets token_age_seconds to the age of the token
+func (m *Metrics) SetTokenAge(age time.Duration) {
+	m.tokenAge.Set(age.Seconds())
+}
+
+// ObserveSearchScore records the score in search_score
+func (m *Metrics) ObserveSearchScore(score float64) {
+	m.searchScore.Observe(score)
+}
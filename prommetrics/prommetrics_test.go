@@ -0,0 +1,66 @@
+package prommetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := New(registry)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	m.ObserveRequest("pds.patient.get", "2xx", 100*time.Millisecond)
+	m.ObserveRequest("pds.patient.get", "2xx", 200*time.Millisecond)
+	m.ObserveRequest("pds.patient.get", "4xx", 50*time.Millisecond)
+	m.IncRetry("pds.patient.search")
+	m.IncRateLimited("pds.patient.search")
+	m.ObserveRateLimitWait(time.Second)
+	m.ObserveTokenRefresh(300*time.Millisecond, false)
+	m.ObserveTokenRefresh(300*time.Millisecond, true)
+	m.SetTokenAge(90 * time.Second)
+	m.ObserveSearchScore(1)
+	m.ObserveSearchScore(0.85)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues("pds.patient.get", "2xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues("pds.patient.get", "4xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.retries.WithLabelValues("pds.patient.search")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.rateLimited.WithLabelValues("pds.patient.search")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.tokenRefreshes.WithLabelValues("success")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.tokenRefreshes.WithLabelValues("error")))
+	assert.Equal(t, float64(90), testutil.ToFloat64(m.tokenAge))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	histograms := map[string]uint64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if h := metric.GetHistogram(); h != nil {
+				histograms[family.GetName()] += h.GetSampleCount()
+			}
+		}
+	}
+	assert.Equal(t, map[string]uint64{
+		"nhs_fhir_request_duration_seconds":       3,
+		"nhs_fhir_rate_limit_wait_seconds":        1,
+		"nhs_fhir_token_refresh_duration_seconds": 2,
+		"nhs_fhir_search_score":                   2,
+	}, histograms)
+}
+
+func TestNew_alreadyRegistered(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if _, err := New(registry); err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	_, err := New(registry)
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInvalidRateLimit error for when the rate limit doesn't allow any requests
+var ErrInvalidRateLimit = errors.New("rate limit TPS must be greater than 0")
+
+// RateLimit the number of transactions per second (TPS) your NHS application is allowed to make
+type RateLimit struct {
+	// TPS the number of requests allowed per second
+	TPS float64
+	// Burst the number of requests which can be sent at once before being limited. Defaults to 1
+	Burst int
+}
+
+// Limiter limits the rate at which requests are sent to the API.
+// A single Limiter can be shared between many clients so that together they stay within one quota.
+type Limiter interface {
+	// Wait blocks until a request is allowed to be sent or the context is done.
+	// It returns the time spent waiting.
+	Wait(ctx context.Context) (time.Duration, error)
+}
+
+// tokenBucket is a Limiter which allows Burst requests at once and refills at TPS tokens per second
+type tokenBucket struct {
+	mu     sync.Mutex
+	tps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewLimiter creates a token bucket Limiter for the rate limit, use this to share a limit between clients
+func NewLimiter(limit RateLimit) (Limiter, error) {
+	if limit.TPS <= 0 {
+		return nil, ErrInvalidRateLimit
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		tps:    limit.TPS,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}, nil
+}
+
+// Wait takes a token from the bucket, waiting for one to be added if it's empty
+func (b *tokenBucket) Wait(ctx context.Context) (time.Duration, error) {
+	wait := b.reserve()
+	if wait <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	start := b.now()
+	select {
+	case <-ctx.Done():
+		b.cancel()
+		return b.now().Sub(start), ctx.Err()
+	case <-timer.C:
+		return wait, nil
+	}
+}
+
+// reserve takes a token from the bucket and returns how long to wait before it can be used
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.tps
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.tps * float64(time.Second))
+}
+
+// cancel returns a reserved token which wasn't used
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// waitForLimiter waits on the clients limiter if one is configured
+func (c *Client) waitForLimiter(ctx context.Context) (time.Duration, error) {
+	if c.limiter == nil {
+		return 0, nil
+	}
+	wait, err := c.limiter.Wait(ctx)
+	if wait > 0 {
+		c.log(ctx, EventRateLimitWait, Field{Key: "wait", Value: wait})
+		c.metrics.rateLimitWait(wait)
+	}
+	return wait, err
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket_reserve(t *testing.T) {
+	now := time.Date(2021, 12, 8, 14, 0, 0, 0, time.UTC)
+	l, err := NewLimiter(RateLimit{TPS: 10, Burst: 2})
+	assert.NoError(t, err)
+	b := l.(*tokenBucket)
+	b.now = func() time.Time { return now }
+
+	// the burst is allowed straight away
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Duration(0), b.reserve())
+
+	// then requests are spaced out at 10 per second
+	assert.Equal(t, 100*time.Millisecond, b.reserve())
+	assert.Equal(t, 200*time.Millisecond, b.reserve())
+
+	// the bucket refills over time but never above the burst
+	now = now.Add(time.Minute)
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, 100*time.Millisecond, b.reserve())
+}
+
+func TestTokenBucket_Wait_contextCancelled(t *testing.T) {
+	l, _ := NewLimiter(RateLimit{TPS: 0.1})
+
+	_, err := l.Wait(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = l.Wait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the cancelled reservation is returned to the bucket
+	assert.InDelta(t, 0, l.(*tokenBucket).tokens, 0.1)
+}
+
+func TestNewLimiter_invalid(t *testing.T) {
+	_, err := NewLimiter(RateLimit{})
+	assert.ErrorIs(t, err, ErrInvalidRateLimit)
+
+	_, err = NewClientWithOptions(&Options{RateLimit: &RateLimit{TPS: -1}})
+	assert.ErrorIs(t, err, ErrInvalidRateLimit)
+}
+
+func TestDo_sharedLimiter(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer svr.Close()
+
+	limiter, _ := NewLimiter(RateLimit{TPS: 50, Burst: 1})
+
+	c1, _ := NewClientWithOptions(&Options{Client: svr.Client(), BaseURL: svr.URL + "/", Limiter: limiter})
+	c2, _ := NewClientWithOptions(&Options{Client: svr.Client(), BaseURL: svr.URL + "/", Limiter: limiter})
+
+	req, _ := c1.newRequest(context.Background(), http.MethodGet, "foo", nil)
+	resp, err := c1.do(context.Background(), req, &struct{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), resp.RateLimitWait)
+
+	req, _ = c2.newRequest(context.Background(), http.MethodGet, "foo", nil)
+	resp, err = c2.do(context.Background(), req, &struct{}{})
+	assert.NoError(t, err)
+	assert.True(t, resp.RateLimitWait > 0, "second client should wait on the shared limiter")
+}
@@ -0,0 +1,321 @@
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RedactionMode how patient data is replaced in traces
+type RedactionMode string
+
+const (
+	// RedactMask replaces the value with a fixed string. This is the default
+	RedactMask RedactionMode = "mask"
+	// RedactHash replaces the value with a keyed hash of it, so the same patient has the same hash in every trace
+	// and requests can still be correlated
+	RedactHash RedactionMode = "hash"
+)
+
+// DefaultRedactedFields the JSON fields redacted from request and response bodies when RedactionPolicy.Fields isn't set.
+// value covers the values of JSON patch operations as well as telecom and identifier values.
+var DefaultRedactedFields = []string{
+	"id",
+	"name",
+	"birthDate",
+	"deceasedDateTime",
+	"address.line",
+	"address.postalCode",
+	"telecom.value",
+	"identifier.value",
+	"value",
+}
+
+// DefaultRedactedQueryParams the query parameters redacted from urls when RedactionPolicy.QueryParams isn't set
+var DefaultRedactedQueryParams = []string{
+	"family",
+	"given",
+	"birthdate",
+	"death-date",
+	"address-postcode",
+	"address-postalcode",
+	"email",
+	"phone",
+	"identifier",
+}
+
+// RedactionPolicy controls how patient data is removed from traces. NHS numbers are redacted wherever they appear in urls and bodies,
+// along with the JSON fields and query parameters of the policy. Access tokens and signed JWTs are always redacted.
+type RedactionPolicy struct {
+	// Disabled set to true to trace patient data, only do this with test data
+	Disabled bool
+	// Mode how redacted values are replaced. Defaults to RedactMask
+	Mode RedactionMode
+	// HashKey the key of the HMAC used by RedactHash, use the same key in every process to correlate requests between them.
+	// Defaults to a random key created when the process starts
+	HashKey []byte
+	// Fields the JSON fields to redact, a field is a dot separated path of object keys which matches the end of the path of a value.
+	// Arrays are part of the path of their items e.g. address.line matches entry.resource.address[0].line. Defaults to DefaultRedactedFields
+	Fields []string
+	// QueryParams the query parameters to redact. Defaults to DefaultRedactedQueryParams
+	QueryParams []string
+}
+
+const redactedURLString = "REDACTED"
+
+// secretFields are always redacted, they let anyone reading the trace call the API
+var secretFields = []string{"access_token", "refresh_token", "id_token", "client_assertion", "subject_token"}
+
+// nhsNumberPattern matches a 10 digit NHS number
+var nhsNumberPattern = regexp.MustCompile(`\b\d{10}\b`)
+
+// processHashKey the hash key used when the policy doesn't have one
+var processHashKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// redactor applies a RedactionPolicy
+type redactor struct {
+	disabled bool
+	mode     RedactionMode
+	key      []byte
+	fields   [][]string
+	params   map[string]bool
+}
+
+// newRedactor creates a redactor for the policy, a nil policy uses the defaults
+func newRedactor(p *RedactionPolicy) *redactor {
+	if p == nil {
+		p = &RedactionPolicy{}
+	}
+	r := &redactor{disabled: p.Disabled, mode: p.Mode, key: p.HashKey, params: map[string]bool{}}
+	if r.mode == "" {
+		r.mode = RedactMask
+	}
+	if len(r.key) == 0 {
+		r.key = processHashKey
+	}
+
+	fields := p.Fields
+	if fields == nil {
+		fields = DefaultRedactedFields
+	}
+	params := p.QueryParams
+	if params == nil {
+		params = DefaultRedactedQueryParams
+	}
+	if r.disabled {
+		fields, params = nil, nil
+	}
+	for _, f := range append(append([]string{}, fields...), secretFields...) {
+		r.fields = append(r.fields, strings.Split(f, "."))
+	}
+	for _, p := range append(append([]string{}, params...), secretFields...) {
+		r.params[strings.ToLower(p)] = true
+	}
+	return r
+}
+
+// replace returns the replacement of a redacted value, mask is used by RedactMask
+func (r *redactor) replace(value, mask string) string {
+	if r.mode != RedactHash {
+		return mask
+	}
+	h := hmac.New(sha256.New, r.key)
+	h.Write([]byte(value))
+	return "hash-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// text redacts the NHS numbers in s
+func (r *redactor) text(s, mask string) string {
+	if r.disabled {
+		return s
+	}
+	return nhsNumberPattern.ReplaceAllStringFunc(s, func(n string) string {
+		return r.replace(n, mask)
+	})
+}
+
+// URL returns the url with the NHS numbers in its path and the redacted query parameters replaced.
+// A query escaped into the path is redacted as well, so a url built with the query in URL.Path doesn't leak it.
+func (r *redactor) URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	path, embedded, _ := strings.Cut(u.Path, "?")
+	values := u.Query()
+	if embedded != "" {
+		parsed, err := url.ParseQuery(embedded)
+		if err != nil {
+			parsed = url.Values{"": {redactedURLString}}
+		}
+		for k, v := range parsed {
+			values[k] = append(values[k], v...)
+		}
+	}
+
+	redacted := *u
+	redacted.RawQuery = ""
+	redacted.Path = r.text(path, redactedURLString)
+	redacted.RawPath = ""
+
+	s := redacted.String()
+	if query := r.query(values); query != "" {
+		s += "?" + query
+	}
+	return s
+}
+
+// urlPattern matches the absolute urls in text, such as the url in the error of a request which couldn't be sent
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"]+`)
+
+// replaceURLs replaces every absolute url in s with the result of replace, a url which can't be parsed is masked
+func replaceURLs(s string, replace func(u *url.URL) string) string {
+	return urlPattern.ReplaceAllStringFunc(s, func(match string) string {
+		u, err := url.Parse(match)
+		if err != nil {
+			return redactedURLString
+		}
+		return replace(u)
+	})
+}
+
+// urlHeaders the headers which hold a url, they can contain a search query
+var urlHeaders = map[string]bool{"Location": true, "Content-Location": true, "Referer": true}
+
+// Header redacts the header in place, urls have their query parameters redacted and every other value its NHS numbers
+func (r *redactor) Header(h http.Header) {
+	for k, values := range h {
+		for i, v := range values {
+			if u, err := url.Parse(v); err == nil && urlHeaders[http.CanonicalHeaderKey(k)] {
+				values[i] = r.URL(u)
+				continue
+			}
+			values[i] = r.text(v, redactedString)
+		}
+	}
+}
+
+// RequestURI returns the path and query of the url with patient data redacted, as sent in the request line
+func (r *redactor) RequestURI(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return r.URL(&url.URL{Path: u.Path, RawQuery: u.RawQuery})
+}
+
+// query encodes the query with the redacted parameters replaced
+func (r *redactor) query(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range values[k] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(k) + "=")
+			if r.params[strings.ToLower(k)] {
+				b.WriteString(r.replace(v, redactedURLString))
+			} else {
+				b.WriteString(url.QueryEscape(r.text(v, redactedURLString)))
+			}
+		}
+	}
+	return b.String()
+}
+
+// Body redacts a request or response body. Forms and JSON have their fields redacted, anything else has its NHS numbers redacted.
+func (r *redactor) Body(contentType string, body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return []byte(r.query(values))
+		}
+	}
+
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return []byte(r.text(string(body), redactedString))
+	}
+	b, err := json.Marshal(r.json(v, nil))
+	if err != nil {
+		return []byte(r.text(string(body), redactedString))
+	}
+	return b
+}
+
+// json redacts the decoded JSON value found at path
+func (r *redactor) json(v interface{}, path []string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			childPath := append(path[:len(path):len(path)], k)
+			if r.matches(childPath) {
+				t[k] = r.value(child)
+				continue
+			}
+			t[k] = r.json(child, childPath)
+		}
+		return t
+	case []interface{}:
+		for i, child := range t {
+			t[i] = r.json(child, path)
+		}
+		return t
+	case string:
+		return r.text(t, redactedString)
+	}
+	return v
+}
+
+// value replaces a redacted JSON value, objects and arrays are replaced as a whole
+func (r *redactor) value(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		b, _ := json.Marshal(v)
+		s = string(b)
+	}
+	return r.replace(s, redactedString)
+}
+
+// matches reports whether a redacted field matches the end of the path
+func (r *redactor) matches(path []string) bool {
+	for _, f := range r.fields {
+		if len(f) > len(path) {
+			continue
+		}
+		tail := path[len(path)-len(f):]
+		match := true
+		for i := range f {
+			if f[i] != tail[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
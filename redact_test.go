@@ -0,0 +1,204 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor_URL(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *RedactionPolicy
+		url    string
+		want   string
+	}{
+		{
+			name: "nhs number in path",
+			url:  "https://sandbox.api.service.nhs.uk/personal-demographics/FHIR/R4/Patient/9000000009",
+			want: "https://sandbox.api.service.nhs.uk/personal-demographics/FHIR/R4/Patient/REDACTED",
+		},
+		{
+			name: "search params",
+			url:  "https://sandbox.api.service.nhs.uk/Patient?family=Smith&gender=female&birthdate=eq2010-10-22&address-postcode=LS1+6AE",
+			want: "https://sandbox.api.service.nhs.uk/Patient?address-postcode=REDACTED&birthdate=REDACTED&family=REDACTED&gender=female",
+		},
+		{
+			name:   "custom query params",
+			policy: &RedactionPolicy{QueryParams: []string{"gender"}},
+			url:    "https://sandbox.api.service.nhs.uk/Patient?family=Smith&gender=female",
+			want:   "https://sandbox.api.service.nhs.uk/Patient?family=Smith&gender=REDACTED",
+		},
+		{
+			name:   "disabled",
+			policy: &RedactionPolicy{Disabled: true},
+			url:    "https://sandbox.api.service.nhs.uk/Patient/9000000009?family=Smith",
+			want:   "https://sandbox.api.service.nhs.uk/Patient/9000000009?family=Smith",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			assert.Equal(t, tt.want, newRedactor(tt.policy).URL(u))
+		})
+	}
+}
+
+func TestRedactor_Body(t *testing.T) {
+	patient := `{"resourceType":"Patient","id":"9000000009","gender":"female","birthDate":"2010-10-22",` +
+		`"name":[{"use":"usual","family":"Smith","given":["Jane"]}],` +
+		`"address":[{"use":"home","line":["1 Trevelyan Square"],"postalCode":"LS1 6AE"}],` +
+		`"telecom":[{"system":"phone","value":"01632960587"}]}`
+
+	tests := []struct {
+		name        string
+		policy      *RedactionPolicy
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			name:        "patient",
+			contentType: "application/fhir+json",
+			body:        patient,
+			want: `{"address":[{"line":"** REDACTED **","postalCode":"** REDACTED **","use":"home"}],"birthDate":"** REDACTED **",` +
+				`"gender":"female","id":"** REDACTED **","name":"** REDACTED **","resourceType":"Patient",` +
+				`"telecom":[{"system":"phone","value":"** REDACTED **"}]}`,
+		},
+		{
+			name:        "search results",
+			contentType: "application/fhir+json",
+			body:        `{"resourceType":"Bundle","entry":[{"fullUrl":"https://api.service.nhs.uk/Patient/9000000009","resource":{"birthDate":"2010-10-22"}}]}`,
+			want:        `{"entry":[{"fullUrl":"https://api.service.nhs.uk/Patient/** REDACTED **","resource":{"birthDate":"** REDACTED **"}}],"resourceType":"Bundle"}`,
+		},
+		{
+			name:        "json patch",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"replace","path":"/address/0/line/0","value":"2 Trevelyan Square"}]`,
+			want:        `[{"op":"replace","path":"/address/0/line/0","value":"** REDACTED **"}]`,
+		},
+		{
+			name:        "custom fields",
+			policy:      &RedactionPolicy{Fields: []string{"gender"}},
+			contentType: "application/json",
+			body:        `{"id":"9000000009","gender":"female","birthDate":"2010-10-22"}`,
+			want:        `{"birthDate":"2010-10-22","gender":"** REDACTED **","id":"** REDACTED **"}`,
+		},
+		{
+			name:        "token request",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "client_assertion=signed.jwt.value&grant_type=client_credentials",
+			want:        "client_assertion=REDACTED&grant_type=client_credentials",
+		},
+		{
+			name:        "token response is redacted when redaction is disabled",
+			policy:      &RedactionPolicy{Disabled: true},
+			contentType: "application/json",
+			body:        `{"access_token":"secret","expires_in":"599"}`,
+			want:        `{"access_token":"** REDACTED **","expires_in":"599"}`,
+		},
+		{
+			name:        "not json",
+			contentType: "text/plain",
+			body:        "patient 9000000009 not found",
+			want:        "patient ** REDACTED ** not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, string(newRedactor(tt.policy).Body(tt.contentType, []byte(tt.body))))
+		})
+	}
+}
+
+func TestRedactor_hash(t *testing.T) {
+	r := newRedactor(&RedactionPolicy{Mode: RedactHash, HashKey: []byte("key")})
+
+	u, _ := url.Parse("https://sandbox.api.service.nhs.uk/Patient/9000000009")
+	redactedURL := r.URL(u)
+	body := string(r.Body("application/json", []byte(`{"id":"9000000009"}`)))
+
+	hash := strings.TrimPrefix(redactedURL, "https://sandbox.api.service.nhs.uk/Patient/")
+	assert.True(t, strings.HasPrefix(hash, "hash-"), "expected a hash got %v", hash)
+	assert.Equal(t, `{"id":"`+hash+`"}`, body, "the same nhs number should have the same hash")
+	assert.NotContains(t, redactedURL+body, "9000000009")
+
+	other := newRedactor(&RedactionPolicy{Mode: RedactHash, HashKey: []byte("other key")})
+	assert.NotEqual(t, redactedURL, other.URL(u))
+}
+
+func TestRedactor_URL_queryInPath(t *testing.T) {
+	u := &url.URL{Scheme: "https", Host: "sandbox.api.service.nhs.uk", Path: "/Patient?family=Smith&gender=female"}
+	assert.Equal(t, "https://sandbox.api.service.nhs.uk/Patient?family=REDACTED&gender=female", newRedactor(nil).URL(u))
+}
+
+func TestRedactor_Header(t *testing.T) {
+	h := http.Header{
+		"Content-Location": {"https://sandbox.api.service.nhs.uk/Patient?family=Smith&_max-results=1"},
+		"Location":         {"/Patient/9000000009"},
+		"X-Request-Id":     {"60E0B220-8136-4CA5-AE46-1D97EF59D068"},
+		"X-Note":           {"patient 9000000009"},
+	}
+	newRedactor(nil).Header(h)
+	assert.Equal(t, "https://sandbox.api.service.nhs.uk/Patient?_max-results=1&family=REDACTED", h.Get("Content-Location"))
+	assert.Equal(t, "/Patient/REDACTED", h.Get("Location"))
+	assert.Equal(t, "60E0B220-8136-4CA5-AE46-1D97EF59D068", h.Get("X-Request-Id"))
+	assert.Equal(t, "patient "+redactedString, h.Get("X-Note"))
+}
+
+func TestPatientService_Search_traceRedacted(t *testing.T) {
+	var query string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/fhir+json")
+		w.Header().Set("Content-Location", "/Patient?"+r.URL.RawQuery)
+		w.Write([]byte(`{"resourceType":"Bundle","entry":[{"resource":{"id":"9000000009"}}]}`))
+	}))
+	defer svr.Close()
+
+	var b bytes.Buffer
+	c, err := NewClientWithOptions(&Options{
+		Client:         svr.Client(),
+		BaseURL:        svr.URL + "/",
+		TracingOptions: &TracingOptions{Enabled: true, Output: &b},
+	})
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+
+	_, _, err = c.Patient.Search(context.Background(), PatientSearchOptions{
+		Family:    createString("Smith"),
+		Postcode:  createString("LS1 6AE"),
+		BirthDate: []*string{createString("eq2010-10-22")},
+	})
+	if err != nil {
+		t.Fatalf("Patient.Search() error = %v", err)
+	}
+
+	// the search is sent as a query rather than escaped into the path
+	assert.Contains(t, query, "family=Smith")
+
+	got := b.String()
+	assert.Contains(t, got, "GET /personal-demographics/FHIR/R4/Patient?")
+	assert.Contains(t, got, "Content-Location: /Patient?")
+	assert.Contains(t, got, "family=REDACTED")
+	for _, data := range []string{"Smith", "LS1", "6AE", "2010-10-22", "9000000009"} {
+		assert.NotContains(t, got, data)
+	}
+}
+
+func TestClient_dumpHTTP_withoutRequest(t *testing.T) {
+	var b bytes.Buffer
+	c, _ := NewClientWithOptions(&Options{TracingOptions: &TracingOptions{Enabled: true, Output: &b}})
+
+	// token requests don't pass the request
+	if err := c.dumpHTTP(nil, &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}); err != nil {
+		t.Errorf("Client.dumpHTTP() without a request error = %v", err)
+	}
+}
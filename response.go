@@ -1,6 +1,9 @@
 package client
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 // Response is for all API responses, it contains the http response.
 type Response struct {
@@ -8,6 +11,14 @@ type Response struct {
 	// RequestID contains a string which is used to uniquely identify the request
 	// Used for debugging or support
 	RequestID string
+	// Attempts the number of times the request was sent, this is more than 1 when the request was retried
+	Attempts int
+	// RateLimitWait the time spent waiting on the client side rate limiter before the request could be sent.
+	// When this is above 0 the request was throttled by this client rather than by the API.
+	RateLimitWait time.Duration
+	// Poller is set when the API accepted the request but the result isn't ready yet and manual polling is enabled.
+	// Use it to get the result of the request.
+	Poller *Poller
 }
 
 func newResponse(r *http.Response) *Response {
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetryPolicy configures how failed requests are retried.
+// Reads are always retried but a PATCH is only retried when it's made safe by an If-Match header.
+type RetryPolicy struct {
+	// MaxAttempts the maximum number of times a request is sent including the first attempt. Defaults to 3
+	MaxAttempts int
+	// BaseBackoff the time to wait before the first retry, this doubles with every attempt. Defaults to 200ms
+	BaseBackoff time.Duration
+	// MaxBackoff the maximum time to wait between attempts. Defaults to 5 seconds
+	MaxBackoff time.Duration
+	// Jitter the fraction of the backoff which is randomised to stop clients retrying at the same time e.g. 0.2.
+	// Must be between 0 and 1
+	Jitter float64
+	// RetryableStatusCodes the status codes which are retried. Defaults to 429, 500, 502, 503 and 504
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy returns the retry policy used for any fields that aren't set
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseBackoff: 200 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+		Jitter:      0.2,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// withDefaults returns a copy of the policy with any missing fields set to their defaults
+func (p RetryPolicy) withDefaults() *RetryPolicy {
+	d := DefaultRetryPolicy()
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = d.MaxAttempts
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = d.BaseBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = d.MaxBackoff
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	if p.RetryableStatusCodes == nil {
+		p.RetryableStatusCodes = d.RetryableStatusCodes
+	}
+	return &p
+}
+
+// isRetryableStatus reports whether the policy retries the status code
+func (p *RetryPolicy) isRetryableStatus(statusCode int) bool {
+	for _, code := range p.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the time to wait before the next attempt, attempt is the number of attempts made so far
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.BaseBackoff) * math.Pow(2, float64(attempt-1))
+	if backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	backoff -= backoff * p.Jitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+// isRetryableRequest reports whether it's safe to send the request again.
+// A PATCH is only safe with an If-Match header as the API rejects it if the first attempt succeeded.
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPatch:
+		return req.Header.Get("If-Match") != ""
+	}
+	return false
+}
+
+// sendWithRetry sends the request, retrying it according to the clients retry policy.
+// It returns the last request sent along with its response and the stats of all the attempts made.
+func (c *Client) sendWithRetry(ctx context.Context, req *http.Request) (*http.Request, *http.Response, *sendStats, error) {
+	stats := &sendStats{}
+	if c.retryPolicy == nil || !isRetryableRequest(req) {
+		resp, err := c.send(ctx, req, stats)
+		return req, resp, stats, err
+	}
+	policy := c.retryPolicy.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, req, stats)
+
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return req, resp, stats, err
+		}
+		if err == nil && !policy.isRetryableStatus(resp.StatusCode) {
+			return req, resp, stats, nil
+		}
+
+		wait := policy.backoff(attempt)
+		fields := requestFields(req.Method, req.URL, req.Header.Get("X-Request-ID"), attempt)
+		if resp != nil {
+			if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); retryAfter > 0 {
+				wait = retryAfter
+			}
+			fields = append(fields, Field{Key: "status", Value: resp.StatusCode})
+			// drain the body so the connection can be reused
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		c.log(ctx, EventRetry, append(fields, Field{Key: "wait", Value: wait})...)
+		c.metrics.retry(ctx)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return req, nil, stats, ctx.Err()
+		case <-timer.C:
+		}
+
+		req, err = newRetryRequest(ctx, req)
+		if err != nil {
+			return req, nil, stats, err
+		}
+	}
+}
+
+// newRetryRequest copies the request so it can be sent again.
+// Every attempt gets a new X-Request-ID otherwise the API rejects it as a duplicate.
+func newRetryRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	retry := req.Clone(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errBodyNotRewindable
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("X-Request-ID", uuid.New().String())
+	return retry, nil
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingServer issues access tokens at /oauth2/token and responds to every other request with the statuses in turn,
+// recording the requests it receives
+type recordingServer struct {
+	*httptest.Server
+	mu             sync.Mutex
+	retryAfter     string
+	requestIDs     []string
+	bodies         []string
+	traceparents   []string
+	correlationIDs []string
+}
+
+// newRecordingServer responds with body and the statuses in turn, the last status is sent once they run out
+func newRecordingServer(body string, statuses ...int) *recordingServer {
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/token" {
+			fmt.Fprintf(w, `{"access_token":"token","expires_in":"599","token_type":"Bearer","issued_at":"%d"}`, time.Now().UnixNano()/int64(time.Millisecond))
+			return
+		}
+		reqBody, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		attempt := len(s.requestIDs)
+		s.requestIDs = append(s.requestIDs, r.Header.Get("X-Request-ID"))
+		s.bodies = append(s.bodies, string(reqBody))
+		s.traceparents = append(s.traceparents, r.Header.Get("traceparent"))
+		s.correlationIDs = append(s.correlationIDs, r.Header.Get("X-Correlation-ID"))
+		retryAfter := s.retryAfter
+		s.mu.Unlock()
+
+		status := statuses[len(statuses)-1]
+		if attempt < len(statuses) {
+			status = statuses[attempt]
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/fhir+json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	return s
+}
+
+// withRetryAfter sends the Retry-After header with every response
+func (s *recordingServer) withRetryAfter(seconds string) *recordingServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retryAfter = seconds
+	return s
+}
+
+func TestDo_retry(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	tests := []struct {
+		name         string
+		policy       *RetryPolicy
+		method       string
+		header       map[string]string
+		body         interface{}
+		statuses     []int
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "retries server errors until success",
+			policy:       policy,
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			wantAttempts: 3,
+		},
+		{
+			name:         "no retry policy sends the request once",
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			wantAttempts: 1,
+			wantErr:      &OperationOutcomeError{},
+		},
+		{
+			name:         "stops after max attempts",
+			policy:       policy,
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusTooManyRequests},
+			wantAttempts: 3,
+			wantErr:      &RateLimitError{},
+		},
+		{
+			name:         "doesn't retry status codes outside of the policy",
+			policy:       policy,
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusNotFound, http.StatusOK},
+			wantAttempts: 1,
+			wantErr:      &OperationOutcomeError{},
+		},
+		{
+			name:         "doesn't retry a patch without If-Match",
+			policy:       policy,
+			method:       http.MethodPatch,
+			body:         map[string]string{"op": "remove"},
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			wantAttempts: 1,
+			wantErr:      &OperationOutcomeError{},
+		},
+		{
+			name:         "retries a patch with If-Match",
+			policy:       policy,
+			method:       http.MethodPatch,
+			header:       map[string]string{"If-Match": `W/"1"`},
+			body:         map[string]string{"op": "remove"},
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			wantAttempts: 2,
+		},
+		{
+			name:         "doesn't retry a post",
+			policy:       policy,
+			method:       http.MethodPost,
+			body:         map[string]string{"foo": "bar"},
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusOK},
+			wantAttempts: 1,
+			wantErr:      &OperationOutcomeError{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := newRecordingServer(`{"Foo":"foo"}`, tt.statuses...)
+			defer svr.Close()
+
+			c := newTestClient(t, svr.Server, &Options{RetryPolicy: tt.policy, DisableAuth: true})
+
+			opts := []requestOption{}
+			for k, v := range tt.header {
+				opts = append(opts, withHeader(k, v))
+			}
+			req, err := c.newRequest(context.Background(), tt.method, "foo", tt.body, opts...)
+			assert.NoError(t, err)
+
+			resp, err := c.do(context.Background(), req, &struct{ Foo string }{})
+
+			switch tt.wantErr.(type) {
+			case nil:
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantAttempts, resp.Attempts)
+				assert.Equal(t, svr.requestIDs[len(svr.requestIDs)-1], resp.RequestID)
+			case *RateLimitError:
+				var rateLimitErr *RateLimitError
+				assert.True(t, errors.As(err, &rateLimitErr), "expected RateLimitError got %v", err)
+				assert.Equal(t, tt.wantAttempts, rateLimitErr.Attempts)
+			case *OperationOutcomeError:
+				var outcome *OperationOutcomeError
+				assert.True(t, errors.As(err, &outcome), "expected OperationOutcomeError got %v", err)
+			}
+
+			assert.Len(t, svr.requestIDs, tt.wantAttempts)
+
+			seen := map[string]bool{}
+			for i, id := range svr.requestIDs {
+				assert.False(t, seen[id], "X-Request-ID should be unique per attempt")
+				seen[id] = true
+				assert.Equal(t, svr.bodies[0], svr.bodies[i], "body should be sent with every attempt")
+			}
+		})
+	}
+}
+
+func TestDo_retryHonoursRetryAfter(t *testing.T) {
+	svr := newRecordingServer(`{"Foo":"foo"}`, http.StatusTooManyRequests, http.StatusOK).withRetryAfter("10")
+	defer svr.Close()
+
+	c := newTestClient(t, svr.Server, &Options{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond},
+		DisableAuth: true,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req, _ := c.newRequest(context.Background(), http.MethodGet, "foo", nil)
+	_, err := c.do(ctx, req, &struct{}{})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, svr.requestIDs, 1, "request should wait for Retry-After before being sent again")
+}
+
+func TestDo_rateLimitRetryAfter(t *testing.T) {
+	svr := newRecordingServer(`{"Foo":"foo"}`, http.StatusTooManyRequests).withRetryAfter("30")
+	defer svr.Close()
+
+	c := newTestClient(t, svr.Server, &Options{DisableAuth: true})
+
+	req, _ := c.newRequest(context.Background(), http.MethodGet, "foo", nil)
+	_, err := c.do(context.Background(), req, &struct{}{})
+
+	var rateLimitErr *RateLimitError
+	assert.True(t, errors.As(err, &rateLimitErr), "expected RateLimitError got %v", err)
+	assert.Equal(t, 30*time.Second, rateLimitErr.RetryAfter)
+	assert.Equal(t, 1, rateLimitErr.Attempts)
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 400*time.Millisecond, p.backoff(3))
+	assert.Equal(t, time.Second, p.backoff(5))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := p.backoff(1)
+		assert.True(t, got > 50*time.Millisecond && got <= 100*time.Millisecond, "backoff with jitter out of range: %v", got)
+	}
+}
+
+func TestRetryPolicy_withDefaults(t *testing.T) {
+	got := RetryPolicy{MaxAttempts: 5, Jitter: 2}.withDefaults()
+	want := DefaultRetryPolicy()
+	want.MaxAttempts = 5
+	want.Jitter = 1
+
+	assert.Equal(t, want, got)
+}
@@ -2,4 +2,8 @@ package client
 
 type service struct {
 	client IClient
+	// telemetry creates the spans of the service's methods
+	telemetry *telemetry
+	// metrics records the measurements of the service's methods, nil when metrics aren't configured
+	metrics *metricsRecorder
 }
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName the name of the tracer which creates the client's spans
+const instrumentationName = "github.com/welldigital/nhs-fhir"
+
+// The names of the OpenTelemetry spans created by the client
+const (
+	// SpanPatientGet a call to PatientService.Get
+	SpanPatientGet = "pds.patient.get"
+	// SpanPatientSearch a call to PatientService.Search
+	SpanPatientSearch = "pds.patient.search"
+	// SpanPatientUpdate a call to PatientService.Update
+	SpanPatientUpdate = "pds.patient.update"
+	// SpanOAuthToken a request for a new access token, it's only created when the token isn't cached
+	SpanOAuthToken = "nhs.oauth.token"
+)
+
+// The attributes set on the client's spans. Like log events they never contain patient data.
+const (
+	// AttrEnvironment the environment called e.g. integration
+	AttrEnvironment = attribute.Key("nhs.environment")
+	// AttrStatusCode the status code of the last response
+	AttrStatusCode = attribute.Key("http.response.status_code")
+	// AttrRetryCount the number of times the request was retried
+	AttrRetryCount = attribute.Key("nhs.retry_count")
+	// AttrRequestID the X-Request-ID of the last request sent
+	AttrRequestID = attribute.Key("nhs.request_id")
+	// AttrCorrelationID the X-Correlation-ID of the request, this is the trace id unless you set your own
+	AttrCorrelationID = attribute.Key("nhs.correlation_id")
+	// AttrTokenFromCache whether the access token was already cached, false when a new token was requested
+	AttrTokenFromCache = attribute.Key("nhs.token.from_cache")
+	// AttrResultCount the number of patients found by a search
+	AttrResultCount = attribute.Key("pds.search.result_count")
+)
+
+// TelemetryOptions the options used to create OpenTelemetry spans for API and token calls
+type TelemetryOptions struct {
+	// TracerProvider creates the tracer of the client's spans. Defaults to otel.GetTracerProvider()
+	TracerProvider trace.TracerProvider
+	// Propagator injects the trace context into the headers of every request. Defaults to otel.GetTextMapPropagator()
+	Propagator propagation.TextMapPropagator
+}
+
+// telemetry creates the spans of a client, a nil telemetry uses the global otel tracer provider and propagator
+type telemetry struct {
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+	environment    Environment
+}
+
+// newTelemetry creates the telemetry for the options, the options can be nil
+func newTelemetry(opts *TelemetryOptions, env Environment) *telemetry {
+	t := &telemetry{environment: env}
+	if opts != nil {
+		t.tracerProvider = opts.TracerProvider
+		t.propagator = opts.Propagator
+	}
+	return t
+}
+
+// tracer returns the tracer of the client, the global provider is read every time so it can be set after the client is created
+func (t *telemetry) tracer() trace.Tracer {
+	provider := otel.GetTracerProvider()
+	if t != nil && t.tracerProvider != nil {
+		provider = t.tracerProvider
+	}
+	return provider.Tracer(instrumentationName)
+}
+
+// clientSpanKey the context key of the span the client started for the operation
+type clientSpanKey struct{}
+
+// start starts a client span for the operation, the span is a child of the span in ctx.
+// The name of the operation is added to the returned context so its requests are measured under it.
+func (t *telemetry) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = withOperation(ctx, name)
+	if t != nil && t.environment != "" {
+		attrs = append(attrs, AttrEnvironment.String(string(t.environment)))
+	}
+	ctx, span := t.tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, clientSpanKey{}, span), span
+}
+
+// clientSpan returns the span the client started for the operation in ctx, so attributes are never set on the caller's own span
+// e.g. when a Poller is called. A span which records nothing is returned if the client didn't start one.
+func clientSpan(ctx context.Context) trace.Span {
+	if span, ok := ctx.Value(clientSpanKey{}).(trace.Span); ok {
+		return span
+	}
+	return trace.SpanFromContext(context.Background())
+}
+
+// inject writes the trace context of ctx into the headers so the API can join the trace
+func (t *telemetry) inject(ctx context.Context, header http.Header) {
+	propagator := otel.GetTextMapPropagator()
+	if t != nil && t.propagator != nil {
+		propagator = t.propagator
+	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// endSpan records the error on the span and ends it, patient data is redacted from the error message
+// in the same way as traces, including the query of any url in it. ErrAccepted isn't recorded as it's expected with manual polling.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrAccepted) {
+		r := newRedactor(nil)
+		msg := r.text(replaceURLs(err.Error(), r.URL), redactedString)
+		span.AddEvent("exception", trace.WithAttributes(
+			attribute.String("exception.type", fmt.Sprintf("%T", err)),
+			attribute.String("exception.message", msg),
+		))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
+
+// recordAttempts sets the attributes of the last request sent and its response on the span
+func recordAttempts(span trace.Span, req *http.Request, resp *http.Response, stats *sendStats) {
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(AttrRetryCount.Int(max(stats.attempts-1, 0)))
+	if req != nil {
+		span.SetAttributes(AttrRequestID.String(req.Header.Get("X-Request-ID")))
+		if id := req.Header.Get("X-Correlation-ID"); id != "" {
+			span.SetAttributes(AttrCorrelationID.String(id))
+		}
+	}
+	if resp != nil {
+		span.SetAttributes(AttrStatusCode.Int(resp.StatusCode))
+	}
+}
@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTelemetryClient(t *testing.T, svr *recordingServer) (*Client, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	c := newTestClient(t, svr.Server, &Options{
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		TelemetryOptions: &TelemetryOptions{
+			TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+			Propagator:     propagation.TraceContext{},
+		},
+	})
+	return c, exporter
+}
+
+// spanAttributes returns the attributes of the span by key
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func TestPatientService_Get_spans(t *testing.T) {
+	svr := newRecordingServer(`{"resourceType":"Patient","id":"9000000009"}`, http.StatusServiceUnavailable, http.StatusOK)
+	defer svr.Close()
+	c, exporter := newTelemetryClient(t, svr)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.Patient.Get(context.Background(), "9000000009"); err != nil {
+			t.Fatalf("Patient.Get() error = %v", err)
+		}
+	}
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	token, first, second := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, SpanOAuthToken, token.Name)
+	assert.Equal(t, first.SpanContext.SpanID(), token.Parent.SpanID(), "the token should be requested in the get span")
+	assert.Equal(t, int64(http.StatusOK), spanAttributes(token)[AttrStatusCode].AsInt64())
+
+	for _, span := range []tracetest.SpanStub{first, second} {
+		assert.Equal(t, SpanPatientGet, span.Name)
+		assert.Equal(t, codes.Unset, span.Status.Code)
+		assert.Equal(t, "custom", spanAttributes(span)[AttrEnvironment].AsString())
+		assert.Equal(t, int64(http.StatusOK), spanAttributes(span)[AttrStatusCode].AsInt64())
+		assert.NotEmpty(t, spanAttributes(span)[AttrRequestID].AsString())
+		assert.Equal(t, span.SpanContext.TraceID().String(), spanAttributes(span)[AttrCorrelationID].AsString())
+	}
+	assert.Equal(t, int64(1), spanAttributes(first)[AttrRetryCount].AsInt64())
+	assert.Equal(t, int64(0), spanAttributes(second)[AttrRetryCount].AsInt64())
+	assert.False(t, spanAttributes(first)[AttrTokenFromCache].AsBool())
+	assert.True(t, spanAttributes(second)[AttrTokenFromCache].AsBool())
+
+	// every attempt carries the trace context of its get span
+	if assert.Len(t, svr.traceparents, 3) {
+		for i, span := range []tracetest.SpanStub{first, first, second} {
+			assert.Equal(t, "00-"+span.SpanContext.TraceID().String()+"-"+span.SpanContext.SpanID().String()+"-01", svr.traceparents[i])
+			assert.Equal(t, span.SpanContext.TraceID().String(), svr.correlationIDs[i])
+		}
+	}
+}
+
+func TestPatientService_Search_spans(t *testing.T) {
+	t.Run("patients found", func(t *testing.T) {
+		svr := newRecordingServer(`{"resourceType":"Bundle","entry":[{"resource":{"id":"9000000009"}},{"resource":{"id":"9000000017"}}]}`, http.StatusOK)
+		defer svr.Close()
+		c, exporter := newTelemetryClient(t, svr)
+
+		if _, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{Family: createString("Smith")}); err != nil {
+			t.Fatalf("Patient.Search() error = %v", err)
+		}
+
+		spans := exporter.GetSpans()
+		if !assert.Len(t, spans, 2) {
+			return
+		}
+		assert.Equal(t, SpanPatientSearch, spans[1].Name)
+		assert.Equal(t, int64(2), spanAttributes(spans[1])[AttrResultCount].AsInt64())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		svr := newRecordingServer(newOutcome("INVALID_SEARCH_DATA", "Invalid search for Smith 9000000009"), http.StatusBadRequest)
+		defer svr.Close()
+		c, exporter := newTelemetryClient(t, svr)
+
+		if _, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{Family: createString("Smith")}); err == nil {
+			t.Fatal("Patient.Search() expected an error")
+		}
+
+		spans := exporter.GetSpans()
+		if !assert.Len(t, spans, 2) {
+			return
+		}
+		search := spans[1]
+		assert.Equal(t, SpanPatientSearch, search.Name)
+		assert.Equal(t, codes.Error, search.Status.Code)
+		assert.Equal(t, int64(http.StatusBadRequest), spanAttributes(search)[AttrStatusCode].AsInt64())
+		assert.Contains(t, search.Status.Description, "INVALID_SEARCH_DATA")
+		assert.NotContains(t, search.Status.Description, "9000000009")
+		if assert.Len(t, search.Events, 1) {
+			assert.Equal(t, "exception", search.Events[0].Name)
+			for _, attr := range search.Events[0].Attributes {
+				assert.False(t, strings.Contains(attr.Value.Emit(), "9000000009"), "exception contains an nhs number: %v", attr.Value.Emit())
+			}
+		}
+	})
+}
+
+func TestClient_postForm_span(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid_request","error_description":"Invalid 'kid' header in JWT - no matching public key"}`))
+	}))
+	defer svr.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	c := newTestClient(t, svr, &Options{
+		DisableAuth: true,
+		TelemetryOptions: &TelemetryOptions{
+			TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		},
+	})
+
+	_, err := c.postForm(context.Background(), svr.URL+"/oauth2/token", map[string][]string{"grant_type": {"client_credentials"}}, &AccessTokenResponse{})
+	assert.ErrorIs(t, err, ErrInvalidKid)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 1) {
+		return
+	}
+	assert.Equal(t, SpanOAuthToken, spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "custom", spanAttributes(spans[0])[AttrEnvironment].AsString())
+	assert.Equal(t, int64(http.StatusUnauthorized), spanAttributes(spans[0])[AttrStatusCode].AsInt64())
+}
+
+func TestPoller_Poll_callerSpan(t *testing.T) {
+	svr := newRecordingServer(`{}`, http.StatusOK)
+	defer svr.Close()
+	c, exporter := newTelemetryClient(t, svr)
+
+	p, err := c.newPoller(&http.Response{Header: http.Header{"Content-Location": {svr.URL + "/poll"}}})
+	if err != nil {
+		t.Fatalf("newPoller() error = %v", err)
+	}
+
+	// the client's attributes only go on its own spans, never on the span of the caller
+	ctx, span := c.telemetry.tracer().Start(context.Background(), "caller")
+	done, _, err := p.Poll(ctx, &struct{}{})
+	span.End()
+	assert.NoError(t, err)
+	assert.True(t, done)
+
+	// the token is requested under the caller's span as well
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	assert.Equal(t, SpanOAuthToken, spans[0].Name)
+	assert.Equal(t, "caller", spans[1].Name)
+	assert.Empty(t, spans[1].Attributes)
+}
+
+func TestPatientService_Search_spanErrorRedacted(t *testing.T) {
+	svr := newRecordingServer(`{}`, http.StatusOK)
+	c, exporter := newTelemetryClient(t, svr)
+	// the token is fetched first so the search is the request which fails
+	if _, err := c.getAccessToken(context.Background()); err != nil {
+		t.Fatalf("getAccessToken() error = %v", err)
+	}
+	svr.Close()
+
+	family, birthdate := "Smith", "eq1990-01-01"
+	_, _, err := c.Patient.Search(context.Background(), PatientSearchOptions{MaxResults: 1, Family: &family, BirthDate: []*string{&birthdate}})
+	if err == nil {
+		t.Fatal("expected an error from the closed server")
+	}
+
+	spans := exporter.GetSpans()
+	if !assert.NotEmpty(t, spans) {
+		return
+	}
+	span := spans[len(spans)-1]
+	assert.Equal(t, codes.Error, span.Status.Code)
+	assert.Contains(t, span.Status.Description, "family="+redactedURLString)
+	assert.NotContains(t, span.Status.Description, "Smith")
+	assert.NotContains(t, span.Status.Description, "1990-01-01")
+}
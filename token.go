@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-querystring/query"
+)
+
+// Token an access token used to authenticate requests to the API
+type Token struct {
+	// AccessToken the token sent in the Authorization header
+	AccessToken string
+	// TokenType the type of token e.g. Bearer
+	TokenType string
+	// Expiry the time the token expires, the zero value means the token doesn't expire
+	Expiry time.Time
+	// IssuedAt the time the token was issued, the zero value means it isn't known
+	IssuedAt time.Time
+}
+
+// expiresWithin reports whether the token is missing, has expired or will expire within the window
+func (t *Token) expiresWithin(window time.Duration, now time.Time) bool {
+	if t == nil || t.AccessToken == "" {
+		return true
+	}
+	if t.Expiry.IsZero() {
+		return false
+	}
+	return !now.Add(window).Before(t.Expiry)
+}
+
+// TokenSource provides the access tokens used to authenticate requests to the API.
+// Implement this to use tokens obtained elsewhere e.g. from your own auth service.
+type TokenSource interface {
+	// Token returns a valid access token
+	Token(ctx context.Context) (*Token, error)
+}
+
+// staticTokenSource always returns the same token
+type staticTokenSource struct {
+	token *Token
+}
+
+// StaticTokenSource returns a TokenSource which always returns the given access token
+func StaticTokenSource(accessToken string) TokenSource {
+	return &staticTokenSource{token: &Token{AccessToken: accessToken, TokenType: "Bearer"}}
+}
+
+func (s *staticTokenSource) Token(ctx context.Context) (*Token, error) {
+	return s.token, nil
+}
+
+// NewJWTTokenSource returns a TokenSource which gets access tokens from NHS auth using a signed JWT,
+// this is the same flow the client uses when it's created with AuthConfigOptions.
+// Tokens are cached and refreshed when they expire within the refresh window.
+// If a nil httpClient is provided then a new http.Client will be used.
+// https://digital.nhs.uk/developer/guides-and-documentation/security-and-authorisation/application-restricted-restful-apis-signed-jwt-authentication
+func NewJWTTokenSource(config AuthConfigOptions, httpClient *http.Client) (TokenSource, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := NewClientWithOptions(&Options{Client: httpClient})
+	if err != nil {
+		return nil, err
+	}
+	return newJWTTokenSource(config, "", c), nil
+}
+
+// newJWTTokenSource creates a cached jwt token source which sends its requests through the client.
+// When the config has a TokenCache the tokens are shared through it as well.
+func newJWTTokenSource(config AuthConfigOptions, env Environment, c *Client) *reuseTokenSource {
+	var source TokenSource = &loggingTokenSource{
+		source:  &jwtTokenSource{config: config, client: c, now: c.clock},
+		log:     c.log,
+		metrics: c.metrics,
+		now:     c.clock,
+	}
+	if config.TokenCache != nil {
+		source = &cachedTokenSource{
+			source: source,
+			cache:  config.TokenCache,
+			key: func() string {
+				return tokenCacheKey(env, config.ClientID, signingKid(config, c.clock()), config.BaseURL)
+			},
+			window: config.refreshWindow(),
+			now:    c.clock,
+		}
+	}
+	return &reuseTokenSource{
+		source: source,
+		window: config.refreshWindow(),
+		now:    c.clock,
+	}
+}
+
+// jwtTokenSource gets a new access token from NHS auth every time it's called
+type jwtTokenSource struct {
+	config AuthConfigOptions
+	client IClient
+	now    func() time.Time
+}
+
+// Token gets a new access token using a newly signed JWT
+// note: the nhs fhir api does not currently provide us with a way to get a refresh token
+// instead it's advised to grab a new access token
+// https://digital.nhs.uk/developer/guides-and-documentation/security-and-authorisation/application-restricted-restful-apis-signed-jwt-authentication#step-8-refresh-token
+func (s *jwtTokenSource) Token(ctx context.Context) (*Token, error) {
+	token, _, err := s.generateAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newToken(token, s.now()), nil
+}
+
+// newToken converts the response of NHS auth into a token.
+// The expiry is worked out from now when the response doesn't say when the token was issued.
+func newToken(res *AccessTokenResponse, now time.Time) *Token {
+	expiry := res.ExpiryTime()
+	issuedAt := time.Unix(0, res.IssuedAt*int64(time.Millisecond))
+	if res.IssuedAt == 0 {
+		expiry = now.Add(time.Duration(res.ExpiresIn) * time.Second)
+		issuedAt = now
+	}
+	return &Token{
+		AccessToken: res.AccessToken,
+		TokenType:   res.TokenType,
+		Expiry:      expiry,
+		IssuedAt:    issuedAt,
+	}
+}
+
+// GenerateToken gets the access token using a newly signed token
+func (s *jwtTokenSource) generateAccessToken(ctx context.Context) (*AccessTokenResponse, *Response, error) {
+
+	path := "/oauth2/token"
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	jwt, err := generateSecret(s.config, s.now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := AccessTokenRequest{
+		GrantType:           "client_credentials",
+		ClientAssertionType: "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+		JWT:                 *jwt,
+	}
+
+	data, err := query.Values(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokenRes := &AccessTokenResponse{}
+
+	res, err := s.client.postForm(ctx, s.config.BaseURL+path, data, tokenRes)
+
+	if err != nil {
+		return nil, res, fmt.Errorf("error generating access token: %w", err)
+	}
+	if tokenRes.AccessToken == "" {
+		return nil, res, fmt.Errorf("error generating access token: %w", ErrAccessTokenMissing)
+	}
+	return tokenRes, res, nil
+}
+
+// tokenRefresh a refresh of the access token which other callers can wait on
+type tokenRefresh struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// reuseTokenSource caches the token from another source until it expires within the refresh window.
+// It's safe to call from many goroutines, only one refresh happens at a time and other callers wait for its result.
+type reuseTokenSource struct {
+	source TokenSource
+	window time.Duration
+	now    func() time.Time
+
+	// mu guards token and refresh
+	mu      sync.Mutex
+	token   *Token
+	refresh *tokenRefresh
+}
+
+// errTokenRefreshPanicked the error the callers waiting on a refresh get when the source panics
+var errTokenRefreshPanicked = errors.New("token source panicked")
+
+// refreshToken gets a new token from the source and finishes the refresh. The refresh is finished even if the source panics,
+// the callers waiting on it get an error instead of blocking forever.
+func (s *reuseTokenSource) refreshToken(ctx context.Context, refresh *tokenRefresh) {
+	refresh.err = errTokenRefreshPanicked
+	defer func() {
+		s.mu.Lock()
+		if refresh.err == nil {
+			s.token = refresh.token
+		}
+		s.refresh = nil
+		s.mu.Unlock()
+		close(refresh.done)
+	}()
+
+	token, err := s.source.Token(ctx)
+	if err == nil && (token == nil || token.AccessToken == "") {
+		// never cache a token which can't be used
+		token, err = nil, ErrAccessTokenMissing
+	}
+	refresh.token, refresh.err = token, err
+}
+
+func (s *reuseTokenSource) Token(ctx context.Context) (*Token, error) {
+	for {
+		s.mu.Lock()
+		if !s.token.expiresWithin(s.window, s.now()) {
+			token := s.token
+			s.mu.Unlock()
+			return token, nil
+		}
+
+		refresh := s.refresh
+		if refresh == nil {
+			refresh = &tokenRefresh{done: make(chan struct{})}
+			s.refresh = refresh
+			s.mu.Unlock()
+
+			s.refreshToken(ctx, refresh)
+			return refresh.token, refresh.err
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-refresh.done:
+		}
+
+		// the caller doing the refresh gave up so try again with our own context
+		if errors.Is(refresh.err, context.Canceled) || errors.Is(refresh.err, context.DeadlineExceeded) {
+			continue
+		}
+		return refresh.token, refresh.err
+	}
+}
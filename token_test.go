@@ -0,0 +1,352 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestToken_expiresWithin(t *testing.T) {
+	now := time.Date(2021, 12, 8, 14, 0, 0, 0, time.UTC)
+	token := &Token{AccessToken: "token", Expiry: now.Add(10 * time.Minute)}
+
+	tests := []struct {
+		name   string
+		token  *Token
+		window time.Duration
+		now    time.Time
+		want   bool
+	}{
+		{name: "valid token", token: token, window: 30 * time.Second, now: now, want: false},
+		{name: "inside the refresh window", token: token, window: 30 * time.Second, now: now.Add(9*time.Minute + 45*time.Second), want: true},
+		{name: "expires exactly now", token: token, window: 0, now: now.Add(10 * time.Minute), want: true},
+		{name: "expired", token: token, window: 0, now: now.Add(time.Hour), want: true},
+		{name: "no expiry", token: &Token{AccessToken: "token"}, window: time.Hour, now: now, want: false},
+		{name: "nil token", token: nil, window: 0, now: now, want: true},
+		{name: "empty token", token: &Token{}, window: 0, now: now, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.token.expiresWithin(tt.window, tt.now))
+		})
+	}
+}
+
+func TestOptions_TokenSource(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer my-token", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"resourceType":"Patient"}`))
+	}))
+	defer svr.Close()
+
+	c, err := NewClientWithOptions(&Options{
+		Client:      svr.Client(),
+		BaseURL:     svr.URL + "/",
+		TokenSource: StaticTokenSource("my-token"),
+		// the token source takes precedence over the auth config
+		AuthConfigOptions: &AuthConfigOptions{BaseURL: "http://invalid"},
+	})
+	assert.NoError(t, err)
+
+	_, _, err = c.Patient.Get(context.Background(), "9000000009")
+	assert.NoError(t, err)
+}
+
+func TestOAuth2Adapters(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Round(0)
+
+	src := FromOAuth2(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "abc", TokenType: "Bearer", Expiry: expiry}))
+	token, err := src.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &Token{AccessToken: "abc", TokenType: "Bearer", Expiry: expiry}, token)
+
+	oauthToken, err := ToOAuth2(context.Background(), src).Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", oauthToken.AccessToken)
+	assert.Equal(t, expiry, oauthToken.Expiry)
+
+	failing := FromOAuth2(oauth2.ReuseTokenSource(nil, errTokenSource{}))
+	_, err = failing.Token(context.Background())
+	assert.Error(t, err)
+}
+
+type errTokenSource struct{}
+
+func (errTokenSource) Token() (*oauth2.Token, error) {
+	return nil, errors.New("bang")
+}
+
+// panicTokenSource panics the first time a token is requested once release is closed, afterwards it returns a token
+type panicTokenSource struct {
+	release chan struct{}
+	calls   int32
+}
+
+func (s *panicTokenSource) Token(ctx context.Context) (*Token, error) {
+	if atomic.AddInt32(&s.calls, 1) == 1 {
+		<-s.release
+		panic("bang")
+	}
+	return &Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestReuseTokenSource_panic(t *testing.T) {
+	source := &panicTokenSource{release: make(chan struct{})}
+	s := &reuseTokenSource{source: source, now: time.Now}
+
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		s.Token(context.Background())
+	}()
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.refresh != nil
+	}, time.Second, time.Millisecond)
+
+	waited := make(chan error)
+	go func() {
+		_, err := s.Token(context.Background())
+		waited <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(source.release)
+
+	assert.Equal(t, "bang", <-panicked)
+	select {
+	case err := <-waited:
+		assert.ErrorIs(t, err, errTokenRefreshPanicked)
+	case <-time.After(time.Second):
+		t.Fatal("the caller waiting on the refresh is blocked")
+	}
+
+	// the next caller refreshes the token again
+	token, err := s.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+}
+
+func TestNewJWTTokenSource(t *testing.T) {
+	_, err := NewJWTTokenSource(AuthConfigOptions{}, nil)
+	assert.ErrorIs(t, err, ErrBaseURLMissing)
+
+	var tokenRequests int32
+	svr := newAuthServer(t, 599, &tokenRequests)
+	defer svr.Close()
+
+	src, err := NewJWTTokenSource(*stubAuthConfig(svr.URL), svr.Client())
+	assert.NoError(t, err)
+
+	// the jwt flow can be used by other http clients
+	httpClient := oauth2.NewClient(context.Background(), ToOAuth2(context.Background(), src))
+	resp, err := httpClient.Get(svr.URL + "/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = httpClient.Get(svr.URL + "/foo")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenRequests), "token should be cached")
+}
+
+func TestJWTTokenSource_tokenErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		wantErrs      []error
+		wantTokenErr  *TokenError
+		wantRetryable bool
+	}{
+		{
+			name:         "invalid kid",
+			status:       http.StatusUnauthorized,
+			body:         `{"error":"invalid_request","error_description":"Invalid 'kid' header in client_assertion JWT - no matching public key","message_id":"rrt-1"}`,
+			wantErrs:     []error{ErrAuthConfig, ErrInvalidKid},
+			wantTokenErr: &TokenError{StatusCode: 401, Code: "invalid_request", Description: "Invalid 'kid' header in client_assertion JWT - no matching public key", MessageID: "rrt-1"},
+		},
+		{
+			name:         "unknown client id",
+			status:       http.StatusUnauthorized,
+			body:         `{"error":"invalid_request","error_description":"Missing or non-matching iss/sub claims in JWT","message_id":"rrt-2"}`,
+			wantErrs:     []error{ErrAuthConfig, ErrUnknownClientID},
+			wantTokenErr: &TokenError{StatusCode: 401, Code: "invalid_request", Description: "Missing or non-matching iss/sub claims in JWT", MessageID: "rrt-2"},
+		},
+		{
+			name:         "wrong audience",
+			status:       http.StatusUnauthorized,
+			body:         `{"error":"invalid_request","error_description":"Missing or invalid aud claim in JWT","message_id":"rrt-3"}`,
+			wantErrs:     []error{ErrAuthConfig, ErrInvalidAudience},
+			wantTokenErr: &TokenError{StatusCode: 401, Code: "invalid_request", Description: "Missing or invalid aud claim in JWT", MessageID: "rrt-3"},
+		},
+		{
+			name:          "jti reused",
+			status:        http.StatusBadRequest,
+			body:          `{"error":"invalid_request","error_description":"Non-unique jti claim in JWT","message_id":"rrt-4"}`,
+			wantTokenErr:  &TokenError{StatusCode: 400, Code: "invalid_request", Description: "Non-unique jti claim in JWT", MessageID: "rrt-4"},
+			wantRetryable: true,
+		},
+		{
+			name:          "auth unavailable",
+			status:        http.StatusBadGateway,
+			body:          `<html>bad gateway</html>`,
+			wantTokenErr:  &TokenError{StatusCode: 502},
+			wantRetryable: true,
+		},
+		{
+			name:     "empty token",
+			status:   http.StatusOK,
+			body:     `{"access_token":"","expires_in":"599","token_type":"Bearer"}`,
+			wantErrs: []error{ErrAccessTokenMissing},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tokenRequests int32
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&tokenRequests, 1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer svr.Close()
+
+			src, err := NewJWTTokenSource(*stubAuthConfig(svr.URL), svr.Client())
+			assert.NoError(t, err)
+
+			_, err = src.Token(context.Background())
+			for _, want := range tt.wantErrs {
+				assert.ErrorIs(t, err, want)
+			}
+			var tokenErr *TokenError
+			if tt.wantTokenErr != nil {
+				if !errors.As(err, &tokenErr) {
+					t.Fatalf("expected a TokenError got %v", err)
+				}
+				assert.Equal(t, tt.wantTokenErr, tokenErr)
+				assert.Equal(t, tt.wantRetryable, tokenErr.Retryable())
+				if tt.wantRetryable {
+					assert.NotErrorIs(t, err, ErrAuthConfig)
+				}
+			}
+
+			// a failed token is never cached
+			_, err = src.Token(context.Background())
+			assert.Error(t, err)
+			assert.Equal(t, int32(2), atomic.LoadInt32(&tokenRequests))
+		})
+	}
+}
+
+func TestTokenError_classify(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		want          error
+		wantRetryable bool
+	}{
+		{
+			name:   "missing kid",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"invalid_request","error_description":"Missing 'kid' header in client_assertion JWT","message_id":"rrt-1"}`,
+			want:   ErrInvalidKid,
+		},
+		{
+			name:   "kid without a public key",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"invalid_request","error_description":"Invalid 'kid' header in client_assertion JWT - no matching public key","message_id":"rrt-2"}`,
+			want:   ErrInvalidKid,
+		},
+		{
+			name:   "iss and sub aren't the client id",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"invalid_request","error_description":"Missing or non-matching 'iss'/'sub' claims in client_assertion JWT","message_id":"rrt-3"}`,
+			want:   ErrUnknownClientID,
+		},
+		{
+			name:   "wrong audience",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"invalid_request","error_description":"Missing or invalid 'aud' claim in client_assertion JWT","message_id":"rrt-4"}`,
+			want:   ErrInvalidAudience,
+		},
+		{
+			name:          "jti reused",
+			status:        http.StatusBadRequest,
+			body:          `{"error":"invalid_request","error_description":"Non-unique 'jti' claim in client_assertion JWT","message_id":"rrt-5"}`,
+			wantRetryable: true,
+		},
+		{
+			name:   "invalid client is classified by its code whatever the description",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"invalid_client","error_description":"Client authentication failed","message_id":"rrt-6"}`,
+			want:   ErrUnknownClientID,
+		},
+		{
+			name:          "temporarily unavailable is classified by its code",
+			status:        http.StatusBadRequest,
+			body:          `{"error":"temporarily_unavailable","error_description":"Try again later","message_id":"rrt-7"}`,
+			wantRetryable: true,
+		},
+		{
+			name:   "a problem which isn't the config",
+			status: http.StatusBadRequest,
+			body:   `{"error":"invalid_request","error_description":"Invalid 'exp' claim in client_assertion JWT - more than 5 minutes in future","message_id":"rrt-8"}`,
+		},
+		{
+			name:   "reworded descriptions fall back to the claim they mention",
+			status: http.StatusUnauthorized,
+			body:   `{"error":"invalid_request","error_description":"No public key found for the kid in the JWT","message_id":"rrt-9"}`,
+			want:   ErrInvalidKid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTokenError(&http.Response{StatusCode: tt.status, Body: ioutil.NopCloser(strings.NewReader(tt.body))})
+			assert.Equal(t, tt.want, err.ConfigError())
+			assert.Equal(t, tt.wantRetryable, err.Retryable())
+			assert.Equal(t, tt.want != nil, errors.Is(err, ErrAuthConfig))
+		})
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	now := time.Date(2021, 10, 22, 12, 0, 0, 0, time.UTC)
+	issued := now.Add(-time.Minute)
+
+	tests := []struct {
+		name         string
+		res          *AccessTokenResponse
+		wantExpiry   time.Time
+		wantIssuedAt time.Time
+	}{
+		{
+			name:         "issued at given",
+			res:          &AccessTokenResponse{AccessToken: "token", ExpiresIn: 599, IssuedAt: issued.UnixNano() / int64(time.Millisecond)},
+			wantExpiry:   issued.Add(599 * time.Second),
+			wantIssuedAt: issued,
+		},
+		{
+			name:         "issued at missing uses the clock",
+			res:          &AccessTokenResponse{AccessToken: "token", ExpiresIn: 599},
+			wantExpiry:   now.Add(599 * time.Second),
+			wantIssuedAt: now,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newToken(tt.res, now)
+			assert.True(t, tt.wantExpiry.Equal(got.Expiry), "expiry = %v, want %v", got.Expiry, tt.wantExpiry)
+			assert.True(t, tt.wantIssuedAt.Equal(got.IssuedAt), "issued at = %v, want %v", got.IssuedAt, tt.wantIssuedAt)
+		})
+	}
+}
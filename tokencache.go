@@ -0,0 +1,416 @@
+package client
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidEncryptionKey error for when the key used to encrypt cached tokens isn't 16, 24 or 32 bytes long
+var ErrInvalidEncryptionKey = errors.New("encryption key must be 16, 24 or 32 bytes long")
+
+// TokenCache stores access tokens so they can be shared between clients, processes and replicas,
+// this stops every short lived worker requesting a new token from NHS auth.
+// Set AuthConfigOptions.TokenCache to use one. Only application-restricted tokens are cached,
+// NHS login tokens belong to a patient and are never shared.
+type TokenCache interface {
+	// Get returns the token stored under key, or nil if there isn't one
+	Get(ctx context.Context, key string) (*Token, error)
+	// Set stores the token under key
+	Set(ctx context.Context, key string, token *Token) error
+	// Lock stops other callers locking key until unlock is called, so only one of them requests a new token.
+	// It blocks until the lock is taken or ctx is done.
+	Lock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// tokenCacheKey the key a token is cached under, tokens are only shared by clients with the same client id and kid
+// calling the same environment. Custom environments are told apart by the url of NHS auth.
+func tokenCacheKey(env Environment, clientID, kid, authURL string) string {
+	if env == "" || env == Custom {
+		env = Environment(strings.TrimSuffix(authURL, "/"))
+	}
+	return fmt.Sprintf("nhs-fhir:token:%v:%v:%v", env, clientID, kid)
+}
+
+// cachedTokenSource shares the tokens of another source through a TokenCache
+type cachedTokenSource struct {
+	source TokenSource
+	cache  TokenCache
+	key    func() string
+	window time.Duration
+	now    func() time.Time
+}
+
+// Token returns the cached token if it's still valid, otherwise it takes the lock and gets a new token.
+// The cache being unavailable doesn't stop a token being returned, the token is requested without it.
+func (s *cachedTokenSource) Token(ctx context.Context) (*Token, error) {
+	key := s.key()
+	if token, err := s.cache.Get(ctx, key); err == nil && !token.expiresWithin(s.window, s.now()) {
+		return token, nil
+	}
+
+	unlock, err := s.cache.Lock(ctx, key)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+	} else {
+		defer unlock()
+		// another process may have refreshed the token while we waited for the lock
+		if token, err := s.cache.Get(ctx, key); err == nil && !token.expiresWithin(s.window, s.now()) {
+			return token, nil
+		}
+	}
+
+	token, err := s.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the token is still usable if it couldn't be cached
+	_ = s.cache.Set(ctx, key, token)
+	return token, nil
+}
+
+// MemoryTokenCache a TokenCache shared by the clients in a process. It's safe for concurrent use.
+type MemoryTokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]Token
+	locks  map[string]chan struct{}
+}
+
+// NewMemoryTokenCache creates an empty in-memory token cache
+func NewMemoryTokenCache() *MemoryTokenCache {
+	return &MemoryTokenCache{tokens: map[string]Token{}, locks: map[string]chan struct{}{}}
+}
+
+// Get returns a copy of the token stored under key
+func (c *MemoryTokenCache) Get(ctx context.Context, key string) (*Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	token, ok := c.tokens[key]
+	if !ok {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+// Set stores a copy of the token under key
+func (c *MemoryTokenCache) Set(ctx context.Context, key string, token *Token) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[key] = *token
+	return nil
+}
+
+// Lock takes the lock of key
+func (c *MemoryTokenCache) Lock(ctx context.Context, key string) (func(), error) {
+	for {
+		c.mu.Lock()
+		held, ok := c.locks[key]
+		if !ok {
+			lock := make(chan struct{})
+			c.locks[key] = lock
+			c.mu.Unlock()
+			return func() {
+				c.mu.Lock()
+				delete(c.locks, key)
+				c.mu.Unlock()
+				close(lock)
+			}, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-held:
+		}
+	}
+}
+
+// cachedToken the stored form of a token
+type cachedToken struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	Expiry      time.Time `json:"expiry"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+func marshalToken(token *Token) ([]byte, error) {
+	return json.Marshal(cachedToken{AccessToken: token.AccessToken, TokenType: token.TokenType, Expiry: token.Expiry, IssuedAt: token.IssuedAt})
+}
+
+func unmarshalToken(b []byte) (*Token, error) {
+	t := cachedToken{}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	return &Token{AccessToken: t.AccessToken, TokenType: t.TokenType, Expiry: t.Expiry, IssuedAt: t.IssuedAt}, nil
+}
+
+// newTokenAEAD creates the AES-GCM cipher which encrypts cached tokens
+func newTokenAEAD(encryptionKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealToken encrypts the token, the key it's cached under is authenticated so it can't be moved to another key
+func sealToken(aead cipher.AEAD, key string, token *Token) ([]byte, error) {
+	plain, err := marshalToken(token)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, []byte(key)), nil
+}
+
+// openToken decrypts a token sealed with sealToken, a token which can't be decrypted is treated as missing
+func openToken(aead cipher.AEAD, key string, b []byte) (*Token, error) {
+	size := aead.NonceSize()
+	if len(b) < size {
+		return nil, nil
+	}
+	plain, err := aead.Open(nil, b[:size], b[size:], []byte(key))
+	if err != nil {
+		// written with another encryption key
+		return nil, nil
+	}
+	return unmarshalToken(plain)
+}
+
+const (
+	// defaultLockTTL how long a lock is held before it's treated as abandoned, e.g. the process holding it crashed
+	defaultLockTTL = 30 * time.Second
+	// lockPollInterval how often a held lock is checked
+	lockPollInterval = 50 * time.Millisecond
+)
+
+// FileTokenCache a TokenCache shared by the processes on a machine, e.g. short lived workers or cron jobs.
+// Tokens are encrypted with AES-GCM so they can't be read from disk without the encryption key.
+// Locks are lock files which are treated as abandoned after 30 seconds.
+type FileTokenCache struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+// NewFileTokenCache creates a token cache which stores its tokens in dir, creating it if needed.
+// encryptionKey must be 16, 24 or 32 bytes long and be the same for every process sharing the cache.
+func NewFileTokenCache(dir string, encryptionKey []byte) (*FileTokenCache, error) {
+	aead, err := newTokenAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileTokenCache{dir: dir, aead: aead}, nil
+}
+
+// path the file the key is stored in, keys are hashed so they're safe to use as file names
+func (c *FileTokenCache) path(key, ext string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+ext)
+}
+
+// Get decrypts the token stored under key, a token which can't be decrypted is treated as missing
+func (c *FileTokenCache) Get(ctx context.Context, key string) (*Token, error) {
+	b, err := os.ReadFile(c.path(key, ".token"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openToken(c.aead, key, b)
+}
+
+// Set encrypts the token and stores it under key, the file is replaced atomically so readers never see part of a token
+func (c *FileTokenCache) Set(ctx context.Context, key string, token *Token) error {
+	sealed, err := sealToken(c.aead, key, token)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(c.dir, "token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(sealed); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path(key, ".token"))
+}
+
+// Lock creates the lock file of key, waiting while another process holds it.
+// The lock file holds a random owner so unlock only removes the lock it created, not one taken after it was abandoned.
+func (c *FileTokenCache) Lock(ctx context.Context, key string) (func(), error) {
+	path := c.path(key, ".lock")
+	owner := uuid.NewString()
+	for {
+		err := createLockFile(path, owner)
+		if err == nil {
+			return func() { removeLockFile(path, owner) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if held, err := os.ReadFile(path); err == nil {
+			if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > defaultLockTTL {
+				// only the abandoned lock is removed, a lock taken since it was read is left alone
+				removeLockFile(path, string(held))
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// createLockFile creates the lock file containing its owner, os.ErrExist is returned if it's already held
+func createLockFile(path, owner string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(owner); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// removeLockFile removes the lock file if it still belongs to owner.
+// The file is moved aside before it's checked so a lock taken by someone else can't be removed in between,
+// if it isn't owner's it's moved back unless the lock has been taken again.
+func removeLockFile(path, owner string) {
+	moved := path + "." + uuid.NewString()
+	if err := os.Rename(path, moved); err != nil {
+		return
+	}
+	defer os.Remove(moved)
+	if b, err := os.ReadFile(moved); err == nil && string(b) == owner {
+		return
+	}
+	os.Link(moved, path)
+}
+
+// KeyValueStore a shared key value store such as Redis or Memcached, adapt it into a TokenCache with NewKeyValueTokenCache
+type KeyValueStore interface {
+	// Get returns the value of key, or nil if it isn't set
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set sets the value of key, it should be removed after ttl. A ttl of 0 means it doesn't expire
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetIfNotExists sets the value of key only if it isn't set and reports whether it was set, e.g. Redis SET NX
+	SetIfNotExists(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// CompareAndDelete removes key only if its value is still value and reports whether it was removed,
+	// e.g. a Redis script comparing the value before calling DEL or Memcached gets and cas
+	CompareAndDelete(ctx context.Context, key string, value []byte) (bool, error)
+}
+
+// keyValueTokenCache stores tokens in a KeyValueStore, aead is nil when tokens are stored in plain text
+type keyValueTokenCache struct {
+	store KeyValueStore
+	aead  cipher.AEAD
+}
+
+// NewKeyValueTokenCache creates a TokenCache which stores tokens in a shared key value store so every replica can use them.
+// Tokens expire from the store when the token does, locks expire after 30 seconds in case their holder crashes.
+// When encryptionKey is set tokens are encrypted with AES-GCM like FileTokenCache, it must be 16, 24 or 32 bytes long
+// and be the same for every replica. A nil key stores the access tokens in plain text, only do this when everything
+// which can read the store is trusted with them.
+func NewKeyValueTokenCache(store KeyValueStore, encryptionKey []byte) (TokenCache, error) {
+	c := &keyValueTokenCache{store: store}
+	if encryptionKey != nil {
+		aead, err := newTokenAEAD(encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		c.aead = aead
+	}
+	return c, nil
+}
+
+func (c *keyValueTokenCache) Get(ctx context.Context, key string) (*Token, error) {
+	b, err := c.store.Get(ctx, key)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	if c.aead != nil {
+		return openToken(c.aead, key, b)
+	}
+	return unmarshalToken(b)
+}
+
+func (c *keyValueTokenCache) Set(ctx context.Context, key string, token *Token) error {
+	var b []byte
+	var err error
+	if c.aead != nil {
+		b, err = sealToken(c.aead, key, token)
+	} else {
+		b, err = marshalToken(token)
+	}
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if !token.Expiry.IsZero() {
+		ttl = time.Until(token.Expiry)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	return c.store.Set(ctx, key, b, ttl)
+}
+
+// Lock sets the lock key to a random owner, unlock only deletes it if it still holds the owner
+// so a lock taken by another replica after this one expired isn't released.
+func (c *keyValueTokenCache) Lock(ctx context.Context, key string) (func(), error) {
+	lockKey := key + ":lock"
+	owner := []byte(uuid.NewString())
+	for {
+		ok, err := c.store.SetIfNotExists(ctx, lockKey, owner, defaultLockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			// the caller's context may be done by the time it unlocks
+			return func() { c.store.CompareAndDelete(context.Background(), lockKey, owner) }, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mapStore an in-memory KeyValueStore
+type mapStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	ttls   map[string]time.Duration
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (s *mapStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key], nil
+}
+
+func (s *mapStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key], s.ttls[key] = value, ttl
+	return nil
+}
+
+func (s *mapStore) SetIfNotExists(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		return false, nil
+	}
+	s.values[key], s.ttls[key] = value, ttl
+	return true, nil
+}
+
+func (s *mapStore) CompareAndDelete(ctx context.Context, key string, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !bytes.Equal(s.values[key], value) {
+		return false, nil
+	}
+	delete(s.values, key)
+	return true, nil
+}
+
+// expire removes key as if its ttl had passed
+func (s *mapStore) expire(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+func newFileTokenCache(t *testing.T, dir string) *FileTokenCache {
+	c, err := NewFileTokenCache(dir, bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatalf("NewFileTokenCache() error = %v", err)
+	}
+	return c
+}
+
+func newKeyValueTokenCache(t *testing.T, store KeyValueStore, encryptionKey []byte) TokenCache {
+	c, err := NewKeyValueTokenCache(store, encryptionKey)
+	if err != nil {
+		t.Fatalf("NewKeyValueTokenCache() error = %v", err)
+	}
+	return c
+}
+
+func TestTokenCache(t *testing.T) {
+	dir := t.TempDir()
+	caches := map[string]TokenCache{
+		"memory":              NewMemoryTokenCache(),
+		"file":                newFileTokenCache(t, dir),
+		"key value":           newKeyValueTokenCache(t, newMapStore(), nil),
+		"encrypted key value": newKeyValueTokenCache(t, newMapStore(), bytes.Repeat([]byte("k"), 32)),
+	}
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			got, err := cache.Get(ctx, "missing")
+			if err != nil || got != nil {
+				t.Fatalf("expected a miss got %v %v", got, err)
+			}
+
+			want := &Token{AccessToken: "abc", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour).Round(0)}
+			if err := cache.Set(ctx, "key", want); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			got, err = cache.Get(ctx, "key")
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			assert.Equal(t, want.AccessToken, got.AccessToken)
+			assert.Equal(t, want.TokenType, got.TokenType)
+			assert.True(t, want.Expiry.Equal(got.Expiry))
+
+			unlock, err := cache.Lock(ctx, "key")
+			if err != nil {
+				t.Fatalf("Lock() error = %v", err)
+			}
+			timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			if _, err := cache.Lock(timeout, "key"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected the lock to be held got %v", err)
+			}
+			unlock()
+			unlock, err = cache.Lock(ctx, "key")
+			if err != nil {
+				t.Fatalf("Lock() after unlock error = %v", err)
+			}
+			unlock()
+		})
+	}
+}
+
+func TestTokenCache_expiredLock(t *testing.T) {
+	dir := t.TempDir()
+	store := newMapStore()
+	tests := []struct {
+		name   string
+		cache  TokenCache
+		expire func()
+	}{
+		{
+			name:  "file",
+			cache: newFileTokenCache(t, dir),
+			expire: func() {
+				// the lock file is older than the lock ttl, as if its holder had crashed
+				files, _ := filepath.Glob(filepath.Join(dir, "*.lock"))
+				old := time.Now().Add(-2 * defaultLockTTL)
+				for _, f := range files {
+					os.Chtimes(f, old, old)
+				}
+			},
+		},
+		{
+			name:   "key value",
+			cache:  newKeyValueTokenCache(t, store, nil),
+			expire: func() { store.expire("key:lock") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			unlockExpired, err := tt.cache.Lock(ctx, "key")
+			if err != nil {
+				t.Fatalf("Lock() error = %v", err)
+			}
+			tt.expire()
+
+			timeout, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			unlock, err := tt.cache.Lock(timeout, "key")
+			if err != nil {
+				t.Fatalf("expected the expired lock to be taken got %v", err)
+			}
+			defer unlock()
+
+			// the holder of the expired lock unlocking mustn't release the new lock
+			unlockExpired()
+			timeout, cancel = context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			if _, err := tt.cache.Lock(timeout, "key"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected the new lock to be held got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileTokenCache_encrypted(t *testing.T) {
+	dir := t.TempDir()
+	cache := newFileTokenCache(t, dir)
+	cache.Set(context.Background(), "key", &Token{AccessToken: "secret-access-token"})
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.token"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 token file got %v", len(files))
+	}
+	b, _ := os.ReadFile(files[0])
+	if bytes.Contains(b, []byte("secret-access-token")) {
+		t.Error("token is stored in plain text")
+	}
+
+	other, _ := NewFileTokenCache(dir, bytes.Repeat([]byte("x"), 32))
+	if got, err := other.Get(context.Background(), "key"); got != nil || err != nil {
+		t.Errorf("expected a miss with the wrong encryption key got %v %v", got, err)
+	}
+
+	if _, err := NewFileTokenCache(dir, []byte("short")); !errors.Is(err, ErrInvalidEncryptionKey) {
+		t.Errorf("expected %v got %v", ErrInvalidEncryptionKey, err)
+	}
+}
+
+func TestKeyValueTokenCache_encrypted(t *testing.T) {
+	store := newMapStore()
+	cache := newKeyValueTokenCache(t, store, bytes.Repeat([]byte("k"), 32))
+	cache.Set(context.Background(), "key", &Token{AccessToken: "secret-access-token"})
+
+	if bytes.Contains(store.values["key"], []byte("secret-access-token")) {
+		t.Error("token is stored in plain text")
+	}
+
+	// a sealed token can't be read under another key
+	store.values["other"] = store.values["key"]
+	if got, err := cache.Get(context.Background(), "other"); got != nil || err != nil {
+		t.Errorf("expected a miss for a token moved to another key got %v %v", got, err)
+	}
+
+	other := newKeyValueTokenCache(t, store, bytes.Repeat([]byte("x"), 32))
+	if got, err := other.Get(context.Background(), "key"); got != nil || err != nil {
+		t.Errorf("expected a miss with the wrong encryption key got %v %v", got, err)
+	}
+
+	if _, err := NewKeyValueTokenCache(store, []byte("short")); !errors.Is(err, ErrInvalidEncryptionKey) {
+		t.Errorf("expected %v got %v", ErrInvalidEncryptionKey, err)
+	}
+}
+
+func TestTokenCacheKey(t *testing.T) {
+	tests := []struct {
+		name string
+		env  Environment
+		want string
+	}{
+		{name: "named environment", env: Integration, want: "nhs-fhir:token:integration:123:test-1"},
+		{name: "custom environment", env: Custom, want: "nhs-fhir:token:https://auth.example.com:123:test-1"},
+		{name: "no environment", want: "nhs-fhir:token:https://auth.example.com:123:test-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tokenCacheKey(tt.env, "123", "test-1", "https://auth.example.com/"))
+		})
+	}
+}
+
+func TestClient_sharedTokenCache(t *testing.T) {
+	_, keyPEM := newPrivateKeyPEM(t)
+	var requests int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":"599","token_type":"Bearer","issued_at":"%d"}`,
+			n, time.Now().UnixNano()/int64(time.Millisecond))
+	}))
+	defer svr.Close()
+
+	// every replica shares the same directory
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		c, err := NewClientWithOptions(&Options{
+			Client: svr.Client(),
+			AuthConfigOptions: &AuthConfigOptions{
+				BaseURL:    svr.URL,
+				ClientID:   "123",
+				Kid:        "test-1",
+				PrivateKey: keyPEM,
+				TokenCache: newFileTokenCache(t, dir),
+			},
+		})
+		if err != nil {
+			t.Fatalf("couldnt init client: %v", err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := c.getAccessToken(context.Background())
+			if err != nil {
+				t.Errorf("couldnt get access token: %v", err)
+				return
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	for _, token := range tokens {
+		assert.Equal(t, "token-1", token)
+	}
+}
@@ -0,0 +1,242 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer receives a copy of every traced request and response, set TracingOptions.Tracer to send traces somewhere other than
+// the text written to TracingOptions.Output e.g. NewJSONTracer for log pipelines.
+// Patient data is redacted from the copy before it's given to the tracer, see RedactionPolicy.
+type Tracer interface {
+	// Trace records the request and response, returning an error fails the request
+	Trace(trace *Trace) error
+}
+
+// Trace a copy of a request and its response
+type Trace struct {
+	// Time the time the response was received
+	Time time.Time
+	// Request the request sent, nil if it isn't known
+	Request *TracedRequest
+	// Response the response received, nil if it isn't known
+	Response *TracedResponse
+}
+
+// TracedRequest a copy of a request with its patient data and Authorization header redacted
+type TracedRequest struct {
+	Method string
+	// URL the redacted url of the request
+	URL    string
+	Host   string
+	Header http.Header
+	Body   []byte
+}
+
+// TracedResponse a copy of a response with its patient data redacted
+type TracedResponse struct {
+	Status     string
+	StatusCode int
+	Proto      string
+	Header     http.Header
+	Body       []byte
+}
+
+// captureRequest copies the request, the body is read from GetBody so the request can still be sent or retried
+func captureRequest(req *http.Request, r *redactor) (*TracedRequest, error) {
+	t := &TracedRequest{
+		Method: req.Method,
+		URL:    r.URL(req.URL),
+		Host:   req.Host,
+		Header: req.Header.Clone(),
+	}
+	if t.Method == "" {
+		t.Method = http.MethodGet
+	}
+	if t.Host == "" && req.URL != nil {
+		t.Host = req.URL.Host
+	}
+	if t.Header == nil {
+		t.Header = http.Header{}
+	}
+	redactFieldFromHeader(&t.Header, "Authorization")
+	r.Header(t.Header)
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		t.Body = r.Body(req.Header.Get("Content-Type"), b)
+	}
+	return t, nil
+}
+
+// captureResponse copies the response, the body is read in full and replaced so it can still be decoded
+func captureResponse(resp *http.Response, r *redactor) (*TracedResponse, error) {
+	t := &TracedResponse{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Proto:      resp.Proto,
+		Header:     resp.Header.Clone(),
+	}
+	if t.Status == "" {
+		t.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	if t.Proto == "" {
+		t.Proto = "HTTP/1.1"
+	}
+	if t.Header == nil {
+		t.Header = http.Header{}
+	}
+	r.Header(t.Header)
+
+	if resp.Body != nil && resp.Body != http.NoBody {
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		t.Body = r.Body(resp.Header.Get("Content-Type"), b)
+	}
+	return t, nil
+}
+
+// textTracer writes traces as HTTP/1.1 messages
+type textTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextTracer creates a tracer which writes the request and response as HTTP/1.1 messages between BEGIN TRACE and END TRACE lines,
+// this is the format used when TracingOptions.Tracer isn't set
+func NewTextTracer(w io.Writer) Tracer {
+	return &textTracer{w: w}
+}
+
+func (t *textTracer) Trace(trace *Trace) error {
+	var b bytes.Buffer
+	b.WriteString("||------------ BEGIN TRACE ------------||\n")
+
+	if req := trace.Request; req != nil {
+		out, err := http.NewRequest(req.Method, req.URL, bytes.NewReader(req.Body))
+		if err != nil {
+			return err
+		}
+		out.Host = req.Host
+		out.Header = req.Header
+		dumpReq, err := httputil.DumpRequestOut(out, len(req.Body) > 0)
+		if err != nil {
+			return err
+		}
+		b.Write(dumpReq)
+	}
+
+	if resp := trace.Response; resp != nil {
+		major, minor, _ := http.ParseHTTPVersion(resp.Proto)
+		out := &http.Response{
+			Status:        resp.Status,
+			StatusCode:    resp.StatusCode,
+			Proto:         resp.Proto,
+			ProtoMajor:    major,
+			ProtoMinor:    minor,
+			Header:        resp.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+			ContentLength: int64(len(resp.Body)),
+		}
+		// the body may have changed length when it was redacted
+		out.Header.Del("Content-Length")
+		dumpResp, err := httputil.DumpResponse(out, len(resp.Body) > 0)
+		if err != nil {
+			return err
+		}
+		b.WriteString(strings.TrimSuffix(string(dumpResp), "\r\n"))
+	}
+
+	b.WriteString("||------------ END TRACE ------------||\n")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.w.Write(b.Bytes())
+	return err
+}
+
+// jsonTracer writes traces as JSON lines
+type jsonTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONTracer creates a tracer which writes each trace as a single line of JSON, for log pipelines.
+// JSON bodies are written as JSON, any other body as a string.
+func NewJSONTracer(w io.Writer) Tracer {
+	return &jsonTracer{w: w}
+}
+
+// jsonTrace the line written by the json tracer
+type jsonTrace struct {
+	Time     time.Time     `json:"time"`
+	Request  *jsonRequest  `json:"request,omitempty"`
+	Response *jsonResponse `json:"response,omitempty"`
+}
+
+type jsonRequest struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Header http.Header     `json:"header,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type jsonResponse struct {
+	StatusCode int             `json:"status_code"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+}
+
+// jsonBody returns the body as JSON, a body which isn't JSON is returned as a JSON string
+func jsonBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		var b bytes.Buffer
+		if err := json.Compact(&b, body); err == nil {
+			return b.Bytes()
+		}
+	}
+	b, _ := json.Marshal(string(body))
+	return b
+}
+
+func (t *jsonTracer) Trace(trace *Trace) error {
+	line := jsonTrace{Time: trace.Time}
+	if req := trace.Request; req != nil {
+		line.Request = &jsonRequest{Method: req.Method, URL: req.URL, Header: req.Header, Body: jsonBody(req.Body)}
+	}
+	if resp := trace.Response; resp != nil {
+		line.Response = &jsonResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: jsonBody(resp.Body)}
+	}
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err = t.w.Write(append(b, '\n'))
+	return err
+}
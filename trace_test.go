@@ -0,0 +1,132 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingTracer keeps every trace
+type recordingTracer struct {
+	traces []*Trace
+}
+
+func (t *recordingTracer) Trace(trace *Trace) error {
+	t.traces = append(t.traces, trace)
+	return nil
+}
+
+func TestClient_do_tracesBodies(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/fhir+json")
+		w.Write([]byte(`{"resourceType":"Patient","id":"9000000009","gender":"female","birthDate":"2010-10-22"}`))
+	}))
+	defer svr.Close()
+
+	tracer := &recordingTracer{}
+	c, err := NewClientWithOptions(&Options{
+		BaseURL:        svr.URL,
+		TracingOptions: &TracingOptions{Enabled: true, Tracer: tracer},
+	})
+	if err != nil {
+		t.Fatalf("couldnt init client: %v", err)
+	}
+
+	req, err := c.newRequest(context.Background(), http.MethodPatch, "Patient/9000000009", []map[string]string{
+		{"op": "replace", "path": "/gender", "value": "female"},
+	})
+	if err != nil {
+		t.Fatalf("couldnt create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+
+	got := map[string]string{}
+	if _, err := c.do(context.Background(), req, &got); err != nil {
+		t.Fatalf("Client.do() error = %v", err)
+	}
+	// the body is still decoded after it's been traced
+	assert.Equal(t, "9000000009", got["id"])
+	assert.Equal(t, "female", got["gender"])
+
+	if !assert.Len(t, tracer.traces, 1) {
+		return
+	}
+	trace := tracer.traces[0]
+	assert.Equal(t, http.MethodPatch, trace.Request.Method)
+	assert.Equal(t, svr.URL+"/Patient/REDACTED", trace.Request.URL)
+	assert.Equal(t, redactedString, trace.Request.Header.Get("Authorization"))
+	assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"), "the request sent shouldn't change")
+	assert.JSONEq(t, `[{"op":"replace","path":"/gender","value":"** REDACTED **"}]`, string(trace.Request.Body))
+	assert.Equal(t, http.StatusOK, trace.Response.StatusCode)
+	assert.JSONEq(t, `{"resourceType":"Patient","id":"** REDACTED **","gender":"female","birthDate":"** REDACTED **"}`, string(trace.Response.Body))
+}
+
+func TestClient_postForm_traced(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"secret-token","expires_in":"599","token_type":"Bearer"}`))
+	}))
+	defer svr.Close()
+
+	var b bytes.Buffer
+	c, _ := NewClientWithOptions(&Options{TracingOptions: &TracingOptions{Enabled: true, Output: &b}})
+
+	tokenRes := &AccessTokenResponse{}
+	if _, err := c.postForm(context.Background(), svr.URL+"/oauth2/token", map[string][]string{"client_assertion": {"signed.jwt"}}, tokenRes); err != nil {
+		t.Fatalf("Client.postForm() error = %v", err)
+	}
+	assert.Equal(t, "secret-token", tokenRes.AccessToken)
+
+	got := b.String()
+	assert.True(t, strings.HasPrefix(got, "||------------ BEGIN TRACE ------------||\nPOST /oauth2/token HTTP/1.1\r\n"), got)
+	assert.Contains(t, got, "client_assertion=REDACTED")
+	assert.Contains(t, got, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, got, `{"access_token":"** REDACTED **","expires_in":"599","token_type":"Bearer"}`)
+	assert.True(t, strings.HasSuffix(got, "||------------ END TRACE ------------||\n"), got)
+	assert.NotContains(t, got, "secret-token")
+	assert.NotContains(t, got, "signed.jwt")
+}
+
+func TestJSONTracer(t *testing.T) {
+	var b bytes.Buffer
+	tracer := NewJSONTracer(&b)
+
+	traces := []*Trace{
+		{
+			Request:  &TracedRequest{Method: http.MethodGet, URL: "https://test.com/Patient/REDACTED", Header: http.Header{}},
+			Response: &TracedResponse{StatusCode: http.StatusOK, Body: []byte(`{"id": "** REDACTED **"}`)},
+		},
+		{
+			Response: &TracedResponse{StatusCode: http.StatusBadGateway, Body: []byte("<html>bad gateway</html>")},
+		},
+	}
+	for _, trace := range traces {
+		if err := tracer.Trace(trace); err != nil {
+			t.Fatalf("Trace() error = %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	first := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("line isn't json: %v", err)
+	}
+	assert.Equal(t, "GET", first["request"].(map[string]interface{})["method"])
+	assert.Equal(t, map[string]interface{}{"id": "** REDACTED **"}, first["response"].(map[string]interface{})["body"])
+
+	second := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("line isn't json: %v", err)
+	}
+	assert.Nil(t, second["request"])
+	assert.Equal(t, "<html>bad gateway</html>", second["response"].(map[string]interface{})["body"])
+}
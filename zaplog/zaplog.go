@@ -0,0 +1,41 @@
+// Package zaplog adapts a zap logger into the Logger of the nhs-fhir client
+package zaplog
+
+import (
+	"context"
+
+	client "github.com/welldigital/nhs-fhir"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logger adapts a zap.Logger into a client.Logger
+type logger struct {
+	logger *zap.Logger
+}
+
+// New returns a client.Logger which logs to the zap logger
+func New(l *zap.Logger) client.Logger {
+	return &logger{logger: l}
+}
+
+func (l *logger) Log(ctx context.Context, level client.Level, msg string, fields ...client.Field) {
+	zapFields := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		zapFields[i] = zap.Any(f.Key, f.Value)
+	}
+	l.logger.Log(Level(level), msg, zapFields...)
+}
+
+// Level converts the level of an event to the zap level
+func Level(level client.Level) zapcore.Level {
+	switch {
+	case level >= client.LevelError:
+		return zapcore.ErrorLevel
+	case level >= client.LevelWarn:
+		return zapcore.WarnLevel
+	case level >= client.LevelInfo:
+		return zapcore.InfoLevel
+	}
+	return zapcore.DebugLevel
+}
@@ -0,0 +1,43 @@
+package zaplog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	client "github.com/welldigital/nhs-fhir"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := New(zap.New(core))
+
+	logger.Log(context.Background(), client.LevelWarn, "request.retry",
+		client.Field{Key: "path", Value: "/Patient/{nhsNumber}"}, client.Field{Key: "attempt", Value: 1})
+
+	entries := logs.All()
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	assert.Equal(t, zapcore.WarnLevel, entries[0].Level)
+	assert.Equal(t, "request.retry", entries[0].Message)
+	assert.Equal(t, map[string]interface{}{"path": "/Patient/{nhsNumber}", "attempt": int64(1)}, entries[0].ContextMap())
+}
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		level client.Level
+		want  zapcore.Level
+	}{
+		{client.LevelDebug, zapcore.DebugLevel},
+		{client.LevelInfo, zapcore.InfoLevel},
+		{client.LevelWarn, zapcore.WarnLevel},
+		{client.LevelError, zapcore.ErrorLevel},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Level(tt.level))
+	}
+}